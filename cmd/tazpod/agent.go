@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- UNLOCK AGENT ---
+//
+// An ssh-agent style helper running as root that remembers unlocked vault
+// keys for features.agent_ttl. Ghost supervisors ask it before prompting and
+// hand it the key after a successful unlock. Only root may read keys; the
+// tazpod user may query the agent and drop the keys.
+
+const (
+	AgentSocket     = SessionDir + "/agent.sock"
+	DefaultAgentTTL = 15 * time.Minute
+)
+
+// keyCache is implemented by backends whose unlocked key can outlive the session.
+type keyCache interface {
+	VolumeKey(passphrase string) []byte
+	UseVolumeKey(key []byte)
+}
+
+type agentEntry struct {
+	key     []byte
+	expires time.Time
+	timer   *time.Timer // wipes the key at expires, clients or not
+}
+
+type agent struct {
+	mu   sync.Mutex
+	keys map[string]*agentEntry
+	ttl  time.Duration
+	stop chan struct{}
+	once sync.Once // a second 'stop' must not close stop again
+}
+
+func agentTTL() time.Duration {
+	if d := parseLimit(cfg.Features.AgentTTL, "agent_ttl"); d > 0 { return d }
+	return DefaultAgentTTL
+}
+
+func (a *agent) wipe(id string) {
+	if e, ok := a.keys[id]; ok { e.timer.Stop(); for i := range e.key { e.key[i] = 0 }; delete(a.keys, id) }
+}
+
+// expire wipes e when its TTL runs out, unless a newer put replaced it.
+func (a *agent) expire(id string, e *agentEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.keys[id] == e { a.wipe(id) }
+}
+
+func (a *agent) handle(conn net.Conn) {
+	defer conn.Close()
+	uid, err := peerUID(conn)
+	if err != nil || (uid != 0 && uid != TazPodUID) { fmt.Fprintln(conn, "error: permission denied"); return }
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil { return }
+	fields := strings.Fields(line)
+	if len(fields) == 0 { return }
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch cmd := fields[0]; {
+	case cmd == "get" && len(fields) == 2 && uid == 0:
+		if e, ok := a.keys[fields[1]]; ok { fmt.Fprintln(conn, "ok "+base64.StdEncoding.EncodeToString(e.key)) } else { fmt.Fprintln(conn, "error: no key") }
+	case cmd == "put" && len(fields) == 3 && uid == 0:
+		key, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil { fmt.Fprintln(conn, "error: bad key"); return }
+		id := fields[1]
+		a.wipe(id)
+		e := &agentEntry{key: key, expires: time.Now().Add(a.ttl)}
+		e.timer = time.AfterFunc(a.ttl, func() { a.expire(id, e) })
+		a.keys[id] = e
+		fmt.Fprintln(conn, "ok")
+	case cmd == "status":
+		if len(a.keys) == 0 { fmt.Fprintln(conn, "ok no keys cached"); return }
+		var soonest time.Time
+		for _, e := range a.keys { if soonest.IsZero() || e.expires.Before(soonest) { soonest = e.expires } }
+		fmt.Fprintf(conn, "ok %d key(s) cached, next expiry in %s\n", len(a.keys), time.Until(soonest).Round(time.Second))
+	case cmd == "lock" || cmd == "stop":
+		for id := range a.keys { a.wipe(id) }
+		fmt.Fprintln(conn, "ok")
+		if cmd == "stop" { a.once.Do(func() { close(a.stop) }) }
+	case cmd == "get" || cmd == "put":
+		fmt.Fprintln(conn, "error: permission denied")
+	default:
+		fmt.Fprintln(conn, "error: unknown command")
+	}
+}
+
+// internalAgent is the root side, started detached by 'tazpod agent'.
+func internalAgent() {
+	if os.Geteuid() != 0 { fmt.Println("❌ internal-agent must run as root."); os.Exit(1) }
+	os.MkdirAll(SessionDir, 0755)
+	os.Remove(AgentSocket)
+	l, err := net.Listen("unix", AgentSocket)
+	if err != nil { fmt.Printf("❌ Cannot listen on %s: %v\n", AgentSocket, err); os.Exit(1) }
+	// Access is enforced per connection with SO_PEERCRED
+	os.Chmod(AgentSocket, 0666)
+	a := &agent{keys: map[string]*agentEntry{}, ttl: agentTTL(), stop: make(chan struct{})}
+	go func() { <-a.stop; l.Close() }()
+	for {
+		conn, err := l.Accept()
+		if err != nil { break }
+		go a.handle(conn)
+	}
+	os.Remove(AgentSocket)
+}
+
+func agentSend(command string) (string, error) {
+	conn, err := net.DialTimeout("unix", AgentSocket, time.Second)
+	if err != nil { return "", err }
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	fmt.Fprintln(conn, command)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil { return "", err }
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "error: ") { return "", fmt.Errorf("%s", strings.TrimPrefix(reply, "error: ")) }
+	return strings.TrimSpace(strings.TrimPrefix(reply, "ok")), nil
+}
+
+func agentKeyID() string { abs, _ := filepath.Abs(backend().Path()); return abs }
+
+// agentGet returns the cached key for the current vault, or nil.
+func agentGet() []byte {
+	reply, err := agentSend("get " + agentKeyID())
+	if err != nil { return nil }
+	key, err := base64.StdEncoding.DecodeString(reply)
+	if err != nil { return nil }
+	return key
+}
+
+func agentPut(key []byte) {
+	if len(key) == 0 { return }
+	if _, err := agentSend("put " + agentKeyID() + " " + base64.StdEncoding.EncodeToString(key)); err == nil {
+		logDebug("Vault key cached by agent")
+	}
+}
+
+func agentRunning() bool { _, err := agentSend("status"); return err == nil }
+
+// agentCmd is the user-facing 'tazpod agent [--lock|--stop|--status]'.
+func agentCmd() {
+	switch {
+	case hasFlag("--lock"), hasFlag("--stop"):
+		cmd := "lock"
+		if hasFlag("--stop") { cmd = "stop" }
+		if _, err := agentSend(cmd); err != nil { fmt.Println("ℹ️  Agent is not running."); return }
+		if cmd == "stop" { fmt.Println("🛑 Agent stopped, cached keys wiped.") } else { fmt.Println("🔒 Agent keys wiped.") }
+	case hasFlag("--status"):
+		reply, err := agentSend("status")
+		if err != nil { fmt.Println("ℹ️  Agent is not running."); os.Exit(1) }
+		fmt.Printf("🗝️  Agent running: %s\n", reply)
+	default:
+		if agentRunning() { fmt.Println("✅ Agent already running."); return }
+		cmd := exec.Command("sudo", "/usr/local/bin/tazpod", "internal-agent")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := cmd.Start(); err != nil { fmt.Printf("❌ Cannot start agent: %v\n", err); os.Exit(1) }
+		cmd.Process.Release()
+		for i := 0; i < 20 && !agentRunning(); i++ { time.Sleep(100 * time.Millisecond) }
+		fmt.Printf("🗝️  Agent started, keys are cached for %s. Use 'tazpod agent --lock' to forget them.\n", agentTTL())
+	}
+}
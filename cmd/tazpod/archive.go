@@ -0,0 +1,306 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+)
+
+// --- PORTABLE VAULT ARCHIVE ---
+//
+// Layout: magic | argon2id time | memory KiB | threads | salt | nonce | AES-256-GCM(gzip(tar))
+// The header is authenticated as additional data, so tampering with the KDF
+// parameters is detected exactly like tampering with the payload.
+
+const (
+	ArchiveMagic   = "TAZPODX1"
+	archiveSaltLen = 16
+	archiveHdrLen  = len(ArchiveMagic) + 4 + 4 + 1 + archiveSaltLen + 12
+	// Bounds on KDF parameters read from a file, so a damaged or crafted
+	// header cannot panic argon2 or ask for an absurd amount of memory.
+	maxKDFMemory = 1024 * 1024 // KiB, 1 GiB
+	maxKDFTime   = 64
+)
+
+var errBadArchive = errors.New("not a TazPod archive or wrong passphrase")
+
+type kdfParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Salt    []byte
+}
+
+func newKDFParams() kdfParams {
+	salt := make([]byte, archiveSaltLen)
+	rand.Read(salt)
+	return kdfParams{Time: 3, Memory: 64 * 1024, Threads: 4, Salt: salt}
+}
+
+func (p kdfParams) key(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, 32)
+}
+
+func sealArchive(passphrase string, plain []byte) ([]byte, error) {
+	p := newKDFParams()
+	return sealWithKey(p, p.key(passphrase), plain)
+}
+
+func sealWithKey(p kdfParams, key, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil { return nil, err }
+	gcm, err := cipher.NewGCM(block)
+	if err != nil { return nil, err }
+	hdr := make([]byte, 0, archiveHdrLen)
+	hdr = append(hdr, ArchiveMagic...)
+	hdr = binary.BigEndian.AppendUint32(hdr, p.Time)
+	hdr = binary.BigEndian.AppendUint32(hdr, p.Memory)
+	hdr = append(hdr, p.Threads)
+	hdr = append(hdr, p.Salt...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil { return nil, err }
+	hdr = append(hdr, nonce...)
+	return gcm.Seal(hdr, nonce, plain, hdr), nil
+}
+
+func parseArchiveHeader(data []byte) (kdfParams, error) {
+	if len(data) < archiveHdrLen || string(data[:len(ArchiveMagic)]) != ArchiveMagic { return kdfParams{}, errBadArchive }
+	off := len(ArchiveMagic)
+	p := kdfParams{
+		Time:    binary.BigEndian.Uint32(data[off:]),
+		Memory:  binary.BigEndian.Uint32(data[off+4:]),
+		Threads: data[off+8],
+	}
+	if p.Time == 0 || p.Time > maxKDFTime || p.Threads == 0 || p.Memory < 8*uint32(p.Threads) || p.Memory > maxKDFMemory { return kdfParams{}, errBadArchive }
+	p.Salt = data[off+9 : off+9+archiveSaltLen]
+	return p, nil
+}
+
+func openArchive(passphrase string, data []byte) ([]byte, error) {
+	p, err := parseArchiveHeader(data)
+	if err != nil { return nil, err }
+	return openWithKey(p.key(passphrase), data)
+}
+
+func openWithKey(key, data []byte) ([]byte, error) {
+	if len(data) < archiveHdrLen { return nil, errBadArchive }
+	block, err := aes.NewCipher(key)
+	if err != nil { return nil, err }
+	gcm, err := cipher.NewGCM(block)
+	if err != nil { return nil, err }
+	hdr := data[:archiveHdrLen]
+	plain, err := gcm.Open(nil, hdr[archiveHdrLen-gcm.NonceSize():], data[archiveHdrLen:], hdr)
+	if err != nil { return nil, errBadArchive }
+	return plain, nil
+}
+
+// packDir serialises root into a gzipped tarball. lost+found belongs to ext4,
+// not to the vault contents, and is never carried over.
+func packDir(root string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		rel, _ := filepath.Rel(root, path)
+		if rel == "." { return nil }
+		if rel == "lost+found" { return filepath.SkipDir }
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 { link, _ = os.Readlink(path) }
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil { return err }
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil { return err }
+		if !info.Mode().IsRegular() { return nil }
+		f, err := os.Open(path)
+		if err != nil { return err }
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil { return nil, err }
+	if err := tw.Close(); err != nil { return nil, err }
+	if err := gz.Close(); err != nil { return nil, err }
+	return buf.Bytes(), nil
+}
+
+// unpackDir extracts a packDir tarball into root and hands every entry to uid:gid.
+// It runs as root on untrusted input, so nothing may land outside root: not
+// by path, not through a symlink the archive created earlier.
+func unpackDir(data []byte, root string, uid, gid int) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil { return err }
+	tr := tar.NewReader(gz)
+	root = filepath.Clean(root)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF { return nil }
+		if err != nil { return err }
+		target := filepath.Join(root, filepath.FromSlash(hdr.Name))
+		if !insideRoot(root, target) || target == root { return fmt.Errorf("unsafe path in archive: %s", hdr.Name) }
+		if symlinkedParent(root, target) { return fmt.Errorf("unsafe path in archive, parent is a symlink: %s", hdr.Name) }
+		// Replace, never write through, a symlink unpacked earlier
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 { os.Remove(target) }
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil { return err }
+		case tar.TypeReg:
+			os.MkdirAll(filepath.Dir(target), 0755)
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil { return err }
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil { return err }
+		case tar.TypeSymlink:
+			link := filepath.FromSlash(hdr.Linkname)
+			if filepath.IsAbs(link) || !insideRoot(root, filepath.Join(filepath.Dir(target), link)) {
+				return fmt.Errorf("unsafe symlink in archive: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			os.MkdirAll(filepath.Dir(target), 0755)
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil { return err }
+		default:
+			continue
+		}
+		os.Lchown(target, uid, gid)
+	}
+}
+
+// insideRoot reports whether the cleaned path is root or below it.
+func insideRoot(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(os.PathSeparator))
+}
+
+// symlinkedParent reports whether any directory between root and target is
+// a symlink, which MkdirAll and OpenFile would follow.
+func symlinkedParent(root, target string) bool {
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil { return true }
+	dir := root
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		if part == "." { continue }
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) { return false }
+		if err != nil || info.Mode()&os.ModeSymlink != 0 { return true }
+	}
+	return false
+}
+
+// unpackedSize returns the total payload size of a packDir tarball in bytes.
+func unpackedSize(data []byte) (int64, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil { return 0, err }
+	tr := tar.NewReader(gz)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF { return total, nil }
+		if err != nil { return 0, err }
+		total += hdr.Size + 512
+	}
+}
+
+func readNewPassphrase(prompt string) string {
+	for {
+		fmt.Printf("📝 %s: ", prompt); p1, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
+		fmt.Print("📝 Confirm: "); p2, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
+		if string(p1) == string(p2) && len(p1) > 0 { return string(p1) }
+		fmt.Println("❌ Passphrases do not match.")
+	}
+}
+
+// --- VAULT SUBCOMMANDS ---
+
+func vaultCmd() {
+	sub := ""
+	if len(os.Args) > 2 { sub = os.Args[2] }
+	switch sub {
+	case "export": vaultExport()
+	case "import": vaultImport()
+	case "check": vaultCheck()
+	default:
+		fmt.Println("Usage: tazpod vault <export|import> <file> | tazpod vault check [--repair]")
+		os.Exit(1)
+	}
+}
+
+func vaultExport() {
+	if len(os.Args) < 4 { fmt.Println("Usage: tazpod vault export <file>"); os.Exit(1) }
+	if os.Getenv(GhostEnvVar) != "true" { fmt.Println("❌ Vault is closed. Run 'tazpod unlock' first."); os.Exit(1) }
+	out := os.Args[3]
+	if abs, err := filepath.Abs(out); err == nil && strings.HasPrefix(abs, MountPath+"/") {
+		fmt.Println("❌ Refusing to write the archive inside the vault itself."); os.Exit(1)
+	}
+
+	fmt.Println("📦 Packing vault contents...")
+	plain, err := packDir(MountPath)
+	if err != nil { fmt.Printf("❌ Cannot read vault: %v\n", err); os.Exit(1) }
+
+	passphrase := readNewPassphrase("Define Archive Passphrase")
+	sealed, err := sealArchive(passphrase, plain)
+	if err != nil { fmt.Printf("❌ Encryption failed: %v\n", err); os.Exit(1) }
+	if err := os.WriteFile(out, sealed, 0600); err != nil { fmt.Printf("❌ Cannot write %s: %v\n", out, err); os.Exit(1) }
+	fmt.Printf("✅ Vault exported to %s (%d KB).\n", out, len(sealed)/1024)
+}
+
+func vaultImport() {
+	if len(os.Args) < 4 { fmt.Println("Usage: tazpod vault import <file>"); os.Exit(1) }
+	if os.Getenv(GhostEnvVar) == "true" { fmt.Println("❌ Exit Ghost Mode first."); os.Exit(1) }
+	archive, err := filepath.Abs(os.Args[3])
+	if err != nil || !fileExist(archive) { fmt.Printf("❌ Archive not found: %s\n", os.Args[3]); os.Exit(1) }
+
+	backup, current := "", backend().Path()
+	if fileExist(current) {
+		fmt.Print("⚠️  A vault already exists. Replace it? (y/N): "); var c string; fmt.Scanln(&c)
+		if strings.ToLower(c) != "y" { return }
+		backup = current + ".bak"
+		if err := os.Rename(current, backup); err != nil { fmt.Printf("❌ Cannot move old vault aside: %v\n", err); os.Exit(1) }
+		fmt.Printf("💾 Previous vault kept at %s\n", backup)
+	}
+
+	err = enterGhost("vault-import", archive)
+	// A failed import leaves no vault behind: a wrong passphrase never creates
+	// one and a failed restore deletes the half-filled one
+	if backup != "" && !fileExist(current) {
+		os.Rename(backup, current)
+		fmt.Println("↩️  Import failed, previous vault restored.")
+	}
+	exitGhost(err)
+}
+
+// internalVaultImport runs inside the ghost namespace, before the new vault
+// exists. It decrypts the archive first so a wrong passphrase never leaves an
+// empty vault behind, then sizes the new vault to fit the contents.
+func internalVaultImport(archive string) []byte {
+	data, err := os.ReadFile(archive)
+	if err != nil { fmt.Printf("❌ Cannot read archive: %v\n", err); os.Exit(1) }
+	fmt.Print("🔑 Archive Passphrase: "); p, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
+	plain, err := openArchive(string(p), data)
+	if err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
+
+	size, err := unpackedSize(plain)
+	if err != nil { fmt.Printf("❌ Corrupted archive: %v\n", err); os.Exit(1) }
+	// ext4 metadata and the LUKS header eat into the image, keep a generous margin
+	if needed := int(size/(1<<20))*5/4 + 64; needed > vaultSizeMB() {
+		fmt.Printf("📐 Growing vault to %d MB to fit the archive.\n", needed)
+		cfg.Vault.SizeMB = needed
+	}
+	return plain
+}
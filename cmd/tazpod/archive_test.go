@@ -0,0 +1,59 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// craftArchive builds a packDir style tarball from headers, regular files
+// get their name as content.
+func craftArchive(t *testing.T, hdrs ...tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, h := range hdrs {
+		if h.Typeflag == tar.TypeReg { h.Size = int64(len(h.Name)) }
+		if h.Mode == 0 { h.Mode = 0600 }
+		if err := tw.WriteHeader(&h); err != nil { t.Fatal(err) }
+		if h.Typeflag == tar.TypeReg { tw.Write([]byte(h.Name)) }
+	}
+	tw.Close(); gz.Close()
+	return buf.Bytes()
+}
+
+func TestUnpackRejectsEscapes(t *testing.T) {
+	for name, hdrs := range map[string][]tar.Header{
+		"path":             {{Name: "../escaped", Typeflag: tar.TypeReg}},
+		"absolute link":    {{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+		"escaping link":    {{Name: "d/a", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+		"symlinked parent": {{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "sub"}, {Name: "a/passwd", Typeflag: tar.TypeReg}},
+	} {
+		dir := t.TempDir()
+		root := filepath.Join(dir, "vault")
+		os.Mkdir(root, 0700)
+		os.Mkdir(filepath.Join(root, "sub"), 0700)
+		err := unpackDir(craftArchive(t, hdrs...), root, os.Getuid(), os.Getgid())
+		if err == nil || !strings.Contains(err.Error(), "unsafe") { t.Errorf("%s: err = %v, want unsafe", name, err) }
+		if _, err := os.Lstat(filepath.Join(dir, "escaped")); err == nil { t.Errorf("%s: wrote outside the root", name) }
+		if _, err := os.Lstat(filepath.Join(root, "sub", "passwd")); err == nil { t.Errorf("%s: wrote through a symlink", name) }
+	}
+}
+
+func TestUnpackRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, ".persist", ".aws"), 0700)
+	os.WriteFile(filepath.Join(src, ".persist", ".aws", "credentials"), []byte("aws"), 0600)
+	os.Symlink("../.persist/.aws/credentials", filepath.Join(src, ".persist", "link"))
+	plain, err := packDir(src)
+	if err != nil { t.Fatal(err) }
+
+	dst := t.TempDir()
+	if err := unpackDir(plain, dst, os.Getuid(), os.Getgid()); err != nil { t.Fatal(err) }
+	if data, err := os.ReadFile(filepath.Join(dst, ".persist", "link")); err != nil || string(data) != "aws" { t.Errorf("through the relative link: %q, %v", data, err) }
+}
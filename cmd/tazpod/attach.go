@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// --- ATTACH ---
+//
+// A second terminal joins an existing ghost session instead of opening the
+// vault again. Joining a mount namespace requires a single-threaded caller,
+// which a Go binary never is, so nsenter performs the setns and re-executes
+// us as internal-attach already inside the supervisor's namespace.
+
+func attach() {
+	if os.Getenv(GhostEnvVar) == "true" { fmt.Println("✅ Already in Ghost Mode."); return }
+	s := selectSession("")
+	if s == nil { fmt.Println("ℹ️  No open ghost session. Run 'tazpod unlock' first."); os.Exit(1) }
+	fmt.Printf("👻 Attaching to ghost session %s...\n", s.ID)
+	args := []string{"nsenter", fmt.Sprintf("--mount=/proc/%d/ns/mnt", s.PID)}
+	// An isolated session's shells share the namespace of its network helper
+	if s.NetPID != 0 { args = append(args, fmt.Sprintf("--net=/proc/%d/ns/net", s.NetPID)) }
+	cmd := exec.Command("sudo", append(args, "/usr/local/bin/tazpod", "internal-attach", s.ID)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	exitGhost(cmd.Run())
+}
+
+func internalAttach() {
+	if os.Geteuid() != 0 { fmt.Println("❌ internal-attach must run as root."); os.Exit(1) }
+	if uid := os.Getenv("SUDO_UID"); uid != "" && uid != strconv.Itoa(TazPodUID) { fmt.Println("❌ Only the tazpod user may attach."); os.Exit(1) }
+	ids := positional()
+	if len(ids) == 0 { os.Exit(1) }
+	if !isMounted(MountPath) { fmt.Println("❌ Vault is not mounted in this namespace."); os.Exit(1) }
+
+	conn, err := net.DialTimeout("unix", SessionDir+"/"+ids[0]+".sock", 2*time.Second)
+	if err != nil { fmt.Printf("❌ Session %s is not reachable: %v\n", ids[0], err); os.Exit(1) }
+	defer conn.Close()
+	fmt.Fprintln(conn, "attach")
+	r := bufio.NewReader(conn)
+	reply, err := r.ReadString('\n')
+	reply = strings.TrimSpace(reply)
+	if err != nil || !strings.HasPrefix(reply, "ok ") { fmt.Printf("❌ Attach refused: %s\n", strings.TrimPrefix(reply, "error: ")); os.Exit(1) }
+	var env []string
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(reply, "ok "))
+	if json.Unmarshal(data, &env) != nil { fmt.Println("❌ Invalid session environment."); os.Exit(1) }
+
+	shell := exec.Command("bash")
+	shell.Stdin, shell.Stdout, shell.Stderr = os.Stdin, os.Stdout, os.Stderr
+	shell.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(TazPodUID), Gid: uint32(TazPodGID)}}
+	shell.Env = env
+	if err := shell.Start(); err != nil { fmt.Printf("❌ Cannot start shell: %v\n", err); os.Exit(1) }
+	fmt.Println("\n✨ ATTACHED TO TAZPOD GHOST SESSION.")
+
+	done := make(chan struct{})
+	locked := make(chan struct{})
+	go func() {
+		// Report input so the supervisor's idle limit sees this terminal too
+		tick := time.NewTicker(autoLockTick)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done: return
+			case <-tick.C:
+			}
+			if since, ok := ttyIdle(os.Stdin); ok && since < autoLockTick { fmt.Fprintln(conn, "active") }
+		}
+	}()
+	go func() {
+		if line, _ := r.ReadString('\n'); strings.TrimSpace(line) == "lock" {
+			close(locked)
+			terminateShell(shell.Process, done)
+		}
+	}()
+	shell.Wait(); close(done)
+	select {
+	case <-locked: os.Exit(ExitLocked)
+	default:
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// --- AUTO-LOCK ---
+//
+// The root supervisor enforces features.ghost_idle_timeout (no keyboard input
+// on the ghost TTY or any attached one) and features.ghost_max_lifetime (wall
+// clock since unlock). Either one warns first, then ends the shells so the
+// normal teardown runs. Attached shells report their input every
+// autoLockTick over the session socket.
+
+const (
+	autoLockGrace = 5 * time.Second
+	autoLockTick  = 5 * time.Second
+)
+
+func parseLimit(value, key string) time.Duration {
+	if value == "" { return 0 }
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 { fmt.Printf("⚠️  Ignoring invalid %s: %q\n", key, value); return 0 }
+	return d
+}
+
+// watchSession runs until done is closed or a limit is hit, then calls lock.
+// Input on the primary TTY or an attached shell counts as activity.
+func watchSession(done <-chan struct{}, lock func()) {
+	idle := parseLimit(cfg.Features.GhostIdleTimeout, "ghost_idle_timeout")
+	lifetime := parseLimit(cfg.Features.GhostMaxLifetime, "ghost_max_lifetime")
+	if idle == 0 && lifetime == 0 { return }
+	logDebug("Auto-lock armed (idle %v, lifetime %v)", idle, lifetime)
+
+	start := time.Now()
+	go func() {
+		tick := time.NewTicker(autoLockTick)
+		defer tick.Stop()
+		warned := false
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+			remaining, reason := time.Duration(1<<62), ""
+			if lifetime > 0 { remaining, reason = lifetime-time.Since(start), "maximum session lifetime" }
+			since, ok := ttyIdle(os.Stdin)
+			if a, aok := currentSession.attachedIdle(); aok && (!ok || a < since) { since, ok = a, true }
+			if idle > 0 && ok && idle-since < remaining { remaining, reason = idle-since, "inactivity" }
+
+			if remaining <= 0 {
+				fmt.Fprintf(os.Stderr, "\r\n\033[1;31m🔒 Locking ghost session (%s).\033[0m\r\n", reason)
+				lock()
+				return
+			}
+			if warnAt := min(time.Minute, max(idle, lifetime)/5); remaining <= warnAt {
+				if !warned { fmt.Fprintf(os.Stderr, "\r\n\033[1;33m⏰ Ghost session locks in %s due to %s.\033[0m\r\n", remaining.Round(time.Second), reason) }
+				warned = true
+			} else {
+				warned = false
+			}
+		}
+	}()
+}
+
+// terminateShell hangs up the ghost shell like a closed terminal would, and
+// kills it if it does not go away on its own.
+func terminateShell(shell *os.Process, done <-chan struct{}) {
+	shell.Signal(syscall.SIGHUP)
+	select {
+	case <-done:
+	case <-time.After(autoLockGrace):
+		shell.Kill()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// --- VAULT BACKENDS ---
+//
+// A backend owns the encrypted container on disk and knows how to expose its
+// plaintext at MountPath inside the ghost namespace, and how to hide it again.
+// Mount exits the process on failure, like the rest of the unlock path.
+
+type vaultBackend interface {
+	Path() string
+	Exists() bool
+	Mount(passphrase string)
+	Unmount()
+}
+
+var activeBackend vaultBackend
+
+func backend() vaultBackend {
+	if activeBackend == nil {
+		switch cfg.Vault.Backend {
+		case "file":
+			activeBackend = &fileBackend{}
+		default:
+			activeBackend = &luksBackend{}
+		}
+	}
+	return activeBackend
+}
+
+// luksBackend is the original loop device + cryptsetup + ext4 vault.
+type luksBackend struct {
+	volumeKey []byte
+}
+
+func (*luksBackend) Path() string              { return VaultPath }
+func (*luksBackend) Exists() bool              { return fileExist(VaultPath) }
+func (b *luksBackend) Mount(passphrase string) { mountVault(passphrase, b.volumeKey) }
+func (b *luksBackend) UseVolumeKey(key []byte) { b.volumeKey = key }
+
+// VolumeKey dumps the LUKS master key through a pipe, so it never lands in a
+// file and never mixes with luksDump's text output.
+func (*luksBackend) VolumeKey(passphrase string) []byte {
+	r, w, err := os.Pipe()
+	if err != nil { return nil }
+	defer r.Close()
+	cmd := exec.Command("cryptsetup", "luksDump", "--dump-volume-key", "--volume-key-file", "/dev/fd/3", "--batch-mode", "--key-file", "-", VaultPath)
+	cmd.Stdin = strings.NewReader(passphrase)
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil { w.Close(); return nil }
+	w.Close()
+	key, _ := io.ReadAll(r)
+	if cmd.Wait() != nil { return nil }
+	return key
+}
+
+func openWithVolumeKey(loopDev string, key []byte) bool {
+	_, err := runWithStdin(string(key), "cryptsetup", "open", "--volume-key-file", "/dev/stdin", loopDev, mapperName)
+	return err == nil
+}
+
+func (*luksBackend) Unmount() {
+	if err := unmount(MountPath); err != nil { fmt.Printf("⚠️  %v\n", err) }
+	cleanupMappers()
+}
+
+// ramBackend backs MountPath with a size-limited tmpfs and nothing else.
+// Secrets are fetched fresh into it and vanish with the ghost namespace.
+type ramBackend struct{}
+
+func (ramBackend) Path() string { return "" }
+func (ramBackend) Exists() bool { return true }
+func (ramBackend) Mount(string) { mountSecretsTmpfs("tazpod_ephemeral") }
+func (ramBackend) Unmount() { unmount(MountPath) }
+
+// mountSecretsTmpfs mounts the size-limited tmpfs that holds plaintext
+// secrets on MountPath, kept out of swap where the kernel allows it.
+func mountSecretsTmpfs(source string) {
+	os.MkdirAll(MountPath, 0700)
+	opts := fmt.Sprintf("size=%dm,mode=0700,uid=%d,gid=%d", vaultSizeMB(), TazPodUID, TazPodGID)
+	if mountFS(source, MountPath, "tmpfs", opts+",noswap") == nil { return }
+	// noswap needs Linux 6.4+, older kernels may page the tmpfs out to swap
+	if err := mountFS(source, MountPath, "tmpfs", opts); err != nil {
+		fmt.Printf("❌ Cannot mount private tmpfs: %v\n", err); os.Exit(1)
+	}
+	fmt.Println("⚠️  Kernel lacks tmpfs 'noswap': decrypted secrets may reach swap.")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileVaultPath is the container used by the userspace backend. It shares the
+// portable archive format, so a file vault is also a valid export.
+const FileVaultPath = VaultDir + "/vault.tpv"
+
+// fileBackend keeps the vault in an authenticated-encrypted file and decrypts
+// it into a private tmpfs. It needs no loop devices, device-mapper or
+// cryptsetup, only the right to mount inside the ghost namespace.
+type fileBackend struct {
+	params  kdfParams
+	key     []byte
+	mounted bool
+}
+
+func (*fileBackend) Path() string { return FileVaultPath }
+func (*fileBackend) Exists() bool { return fileExist(FileVaultPath) }
+
+func (b *fileBackend) Mount(passphrase string) {
+	if passphrase == "" && isMounted(MountPath) { return }
+	var plain []byte
+	if data, err := os.ReadFile(FileVaultPath); err == nil {
+		p, err := parseArchiveHeader(data)
+		if err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
+		b.params = p
+		if b.key != nil {
+			if plain, err = openWithKey(b.key, data); err != nil { fmt.Println("⚠️  Cached vault key was rejected."); passphrase = readPassphrase(false) }
+		}
+		if plain == nil {
+			b.key = p.key(passphrase)
+			if plain, err = openWithKey(b.key, data); err != nil { fmt.Println("❌ DECRYPTION FAILED."); os.Exit(1) }
+		}
+	} else {
+		logDebug("Creating new file vault...")
+		b.params = newKDFParams(); b.key = b.params.key(passphrase)
+	}
+
+	mountSecretsTmpfs("tazpod_vault")
+	if plain != nil {
+		if err := unpackDir(plain, MountPath, TazPodUID, TazPodGID); err != nil { fmt.Printf("❌ Corrupted vault: %v\n", err); b.discard(); os.Exit(1) }
+	} else if err := b.save(); err != nil {
+		fmt.Printf("❌ Cannot create vault: %v\n", err); b.discard(); os.Exit(1)
+	}
+	b.mounted = true
+}
+
+func (b *fileBackend) UseVolumeKey(key []byte) { b.key = key }
+
+// VolumeKey hands out a copy, discard wipes the original on lock.
+func (b *fileBackend) VolumeKey(string) []byte { return append([]byte(nil), b.key...) }
+
+// Unmount re-encrypts the tmpfs contents and only then throws them away. If
+// sealing fails the previous container is left untouched on disk.
+func (b *fileBackend) Unmount() {
+	if b.key == nil { return }
+	// An unlock interrupted halfway has nothing new, saving it would lose data
+	if b.mounted {
+		if err := b.save(); err != nil { fmt.Printf("❌ Could not save vault, changes from this session are lost: %v\n", err) }
+	}
+	b.discard()
+}
+
+func (b *fileBackend) save() error {
+	plain, err := packDir(MountPath)
+	if err != nil { return err }
+	sealed, err := sealWithKey(b.params, b.key, plain)
+	if err != nil { return err }
+	os.MkdirAll(VaultDir, 0755)
+	if err := writeFileAtomic(FileVaultPath, sealed, 0600); err != nil { return err }
+	os.Chown(FileVaultPath, TazPodUID, TazPodGID)
+	return nil
+}
+
+func (b *fileBackend) discard() {
+	if err := unmount(MountPath); err != nil { fmt.Printf("⚠️  %v\n", err) }
+	for i := range b.key { b.key[i] = 0 }
+	b.key, b.mounted = nil, false
+}
+
+// writeFileAtomic replaces path with data so that readers see either the old
+// or the new content, never a torn write.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil { return err }
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil { tmp.Close(); return err }
+	if err := tmp.Chmod(mode); err != nil { tmp.Close(); return err }
+	if err := tmp.Sync(); err != nil { tmp.Close(); return err }
+	if err := tmp.Close(); err != nil { return err }
+	if err := os.Rename(tmp.Name(), path); err != nil { return err }
+	if dir, err := os.Open(filepath.Dir(path)); err == nil { dir.Sync(); dir.Close() }
+	return nil
+}
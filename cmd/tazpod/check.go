@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- INTEGRITY CHECK & REPAIR ---
+
+// ManifestFile records a hash of every file in the vault, refreshed on each
+// clean lock. A mismatch on the next check means the last session did not
+// end cleanly or the filesystem lost data.
+const ManifestFile = MountPath + "/.tazpod-manifest"
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil { return "", err }
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil { return "", err }
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func scanVault() (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(MountPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		rel, _ := filepath.Rel(MountPath, path)
+		if rel == "lost+found" { return filepath.SkipDir }
+		if !info.Mode().IsRegular() || path == ManifestFile { return nil }
+		sum, err := hashFile(path)
+		if err != nil { return err }
+		files[rel] = sum
+		return nil
+	})
+	return files, err
+}
+
+func writeManifest() error {
+	files, err := scanVault()
+	if err != nil { return err }
+	names := make([]string, 0, len(files))
+	for name := range files { names = append(names, name) }
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names { fmt.Fprintf(&b, "%s  %s\n", files[name], name) }
+	if err := writeFileAtomic(ManifestFile, []byte(b.String()), 0600); err != nil { return err }
+	return os.Chown(ManifestFile, TazPodUID, TazPodGID)
+}
+
+// verifyManifest compares the vault against the manifest from the last lock.
+// It returns ok=false with no diff when there is no manifest yet.
+func verifyManifest() (missing, changed, added []string, ok bool) {
+	f, err := os.Open(ManifestFile)
+	if err != nil { return nil, nil, nil, false }
+	defer f.Close()
+	want := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if sum, name, found := strings.Cut(sc.Text(), "  "); found { want[name] = sum }
+	}
+	have, err := scanVault()
+	if err != nil { fmt.Printf("  ❌ Cannot read vault contents: %v\n", err); return nil, nil, nil, false }
+	for name, sum := range want {
+		if got, exists := have[name]; !exists { missing = append(missing, name) } else if got != sum { changed = append(changed, name) }
+	}
+	for name := range have { if _, exists := want[name]; !exists { added = append(added, name) } }
+	sort.Strings(missing); sort.Strings(changed); sort.Strings(added)
+	return missing, changed, added, true
+}
+
+// runFsck runs e2fsck on the open mapper. mode is "-n" (read only), "-p"
+// (automatic safe fixes) or "-y" (fix everything). Exit codes below 4 mean
+// the filesystem is now consistent.
+func runFsck(dev, mode string) bool {
+	logDebug("fsck.ext4 %s %s", mode, dev)
+	cmd := exec.Command("fsck.ext4", mode, dev)
+	out, err := cmd.CombinedOutput()
+	code := 0
+	if ee, ok := err.(*exec.ExitError); ok { code = ee.ExitCode() } else if err != nil { fmt.Printf("  ❌ fsck unavailable: %v\n", err); return false }
+	switch {
+	case code == 0:
+		return true
+	case code < 4:
+		fmt.Println("  🔧 Filesystem errors were found and corrected.")
+		return true
+	default:
+		fmt.Printf("  ❌ Filesystem check failed (fsck exit %d):\n%s", code, out)
+		return false
+	}
+}
+
+func vaultCheck() {
+	if os.Getenv(GhostEnvVar) == "true" { fmt.Println("❌ Exit Ghost Mode first, the vault must not be in use."); os.Exit(1) }
+	args := []string{"vault-check"}
+	if hasFlag("--repair") { args = append(args, "--repair") }
+	if code := exitCode(enterGhost(args...)); code != 0 { os.Exit(code) }
+}
+
+// internalVaultCheck runs as root in the ghost namespace and never spawns a shell.
+func internalVaultCheck(repair bool) {
+	be := backend()
+	if !be.Exists() { fmt.Println("❌ No vault found."); os.Exit(1) }
+	healthy := true
+	fmt.Println("🩺 Checking vault...")
+
+	switch b := be.(type) {
+	case *fileBackend:
+		data, err := os.ReadFile(FileVaultPath)
+		if err == nil { _, err = parseArchiveHeader(data) }
+		if err != nil { fmt.Printf("  ❌ Container header: %v\n", err); os.Exit(1) }
+		fmt.Println("  ✅ Container header OK")
+		b.Mount(performUnlock())
+		fmt.Println("  ✅ Authenticated encryption OK (contents intact)")
+	case *luksBackend:
+		if exec.Command("cryptsetup", "isLuks", VaultPath).Run() != nil { fmt.Println("  ❌ LUKS header missing or damaged."); os.Exit(1) }
+		version := "?"
+		for _, line := range strings.Split(runOutput("cryptsetup", "luksDump", VaultPath), "\n") {
+			if strings.HasPrefix(line, "Version:") { version = strings.TrimSpace(strings.TrimPrefix(line, "Version:")) }
+		}
+		fmt.Printf("  ✅ LUKS header OK (version %s)\n", version)
+		passphrase := performUnlock()
+		openVault(passphrase, nil)
+		mapper := "/dev/mapper/" + mapperName
+		mode := "-n"
+		if repair { mode = "-y" }
+		if runFsck(mapper, mode) { fmt.Println("  ✅ Filesystem OK") } else { healthy = false }
+		if healthy || repair {
+			os.MkdirAll(MountPath, 0755)
+			opts := "ro"
+			if repair { opts = "rw" }
+			if err := mountFS(mapper, MountPath, "ext4", opts); err != nil {
+				fmt.Printf("  ❌ Cannot mount vault: %v\n", err); cleanupMappers(); os.Exit(1)
+			}
+		}
+	default:
+		fmt.Println("ℹ️  Ephemeral sessions have nothing on disk to check."); return
+	}
+
+	if isMounted(MountPath) {
+		missing, changed, added, ok := verifyManifest()
+		switch {
+		case !ok:
+			fmt.Println("  ℹ️  No content manifest yet, one is written on the next lock.")
+		case len(missing)+len(changed) == 0:
+			fmt.Printf("  ✅ Contents match manifest (%d new since last lock)\n", len(added))
+		default:
+			healthy = false
+			for _, n := range missing { fmt.Printf("  ❌ missing:  %s\n", n) }
+			for _, n := range changed { fmt.Printf("  ⚠️  changed:  %s\n", n) }
+		}
+		if repair {
+			if err := writeManifest(); err == nil { fmt.Println("  🔧 Manifest rebuilt from current contents.") }
+		}
+	}
+
+	if b, ok := be.(*fileBackend); ok {
+		if repair { b.Unmount() } else { b.discard() }
+	} else {
+		be.Unmount()
+	}
+	if !healthy && !repair { fmt.Println("❌ Vault has problems. Run 'tazpod vault check --repair'."); os.Exit(1) }
+	fmt.Println("✅ Vault check complete.")
+}
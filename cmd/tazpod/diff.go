@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// --- SECRETS DIFF ---
+//
+// 'pull --dry-run' and 'tazpod secrets diff' fetch into memory like a pull
+// and compare the result with the vault by SHA-256: mapped files in
+// MountPath and the keys of EnvFile. Only names are printed, never values.
+// 'pull --check' also exits with ExitStale when the vault is behind its
+// providers, for prompts and CI.
+
+// ExitStale is the exit status of 'pull --check' when the vault is stale.
+const ExitStale = 4
+
+type secretChange struct {
+	kind byte // '+' added, '-' removed, '~' changed
+	name string
+}
+
+// diffSecrets compares the providers with the vault and returns the exit
+// status for the command.
+func diffSecrets(check bool) int {
+	internalEnsureAuth()
+	set, err := fetchPull(true)
+	if err != nil { fmt.Printf("❌ Cannot compare: %v.\n", err); return 1 }
+	changes := set.diff()
+
+	if prev := pulledEnv(); prev != "" && prev != set.env {
+		fmt.Printf("\n🔀 The vault holds %s, this compares against %s.\n", prev, set.env)
+	}
+	if len(changes) == 0 {
+		fmt.Printf("\n✅ Vault is up to date with %s.\n", set.env)
+		return 0
+	}
+	counts := map[byte]int{}
+	fmt.Printf("\n🔍 Changes from %s:\n", set.env)
+	for _, c := range changes {
+		counts[c.kind]++
+		fmt.Printf("   %c %s\n", c.kind, c.name)
+	}
+	fmt.Printf("📊 %d added, %d changed, %d removed\n", counts['+'], counts['~'], counts['-'])
+	if check { return ExitStale }
+	return 0
+}
+
+// diff lists what a pull of this set would change in the vault. Failed
+// optional mappings and a failed export are left out: the pull keeps them.
+// A secret deleted upstream is a removal of the file the vault still holds.
+func (s *pullSet) diff() []secretChange {
+	var changes []secretChange
+	for _, r := range s.results {
+		name := r.label + " (" + strings.TrimPrefix(r.target, MountPath+"/") + ")"
+		if r.removed() && r.target != "" {
+			if _, err := os.Stat(r.target); err == nil { changes = append(changes, secretChange{'-', name}) }
+			continue
+		}
+		if r.err != nil { continue }
+		old, err := os.ReadFile(r.target)
+		if err != nil {
+			changes = append(changes, secretChange{'+', name})
+		} else if sha256.Sum256(old) != sha256.Sum256(r.value) {
+			changes = append(changes, secretChange{'~', name})
+		}
+	}
+	if !s.exportsOK { return changes }
+
+	old, _ := os.ReadFile(EnvFile)
+	before, after := exportHashes(old), exportHashes(s.exports)
+	var keys []string
+	for k := range before { keys = append(keys, k) }
+	for k := range after {
+		if _, ok := before[k]; !ok { keys = append(keys, k) }
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b, had := before[k]
+		a, has := after[k]
+		switch {
+		case !had: changes = append(changes, secretChange{'+', "$" + k})
+		case !has: changes = append(changes, secretChange{'-', "$" + k})
+		case a != b: changes = append(changes, secretChange{'~', "$" + k})
+		}
+	}
+	return changes
+}
+
+// removed reports whether the provider no longer has this secret.
+func (r fetchResult) removed() bool {
+	var miss *missingError
+	return errors.As(r.err, &miss)
+}
+
+// exportHashes hashes every value of a dotenv export file by key.
+func exportHashes(data []byte) map[string][sha256.Size]byte {
+	hashes := map[string][sha256.Size]byte{}
+	for k, v := range parseExports(data) { hashes[k] = sha256.Sum256([]byte(v)) }
+	return hashes
+}
+
+// parseExports reads "export KEY='value'" lines as written by the providers.
+func parseExports(data []byte) map[string]string {
+	vars := map[string]string{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		kv, ok := strings.CutPrefix(strings.TrimSpace(string(line)), "export ")
+		if !ok { continue }
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok { continue }
+		if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+			v = strings.ReplaceAll(v[1:len(v)-1], `'\''`, "'")
+		} else {
+			v = strings.Trim(v, "'\"")
+		}
+		vars[k] = v
+	}
+	return vars
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// --- ENVIRONMENTS ---
+//
+// 'pull' fetches one environment (dev, staging, prod...). It is chosen by,
+// in order: 'pull --env <slug>', $TAZPOD_ENV, the config.branches table
+// matched against the current git branch, config.environment, and finally
+// "dev". The ghost shell shows the environment last pulled in
+// $TAZPOD_PROMPT_ENV instead, so a branch switch still takes effect there. A mapping can
+// pin its own with 'environment:', and vault and sops paths may contain
+// {env}.
+
+const (
+	EnvVar     = "TAZPOD_ENV"
+	DefaultEnv = "dev"
+	// PromptEnvVar is exported to the ghost shell for its prompt only.
+	PromptEnvVar = "TAZPOD_PROMPT_ENV"
+	// ActiveEnvFile remembers which environment the vault contents came from.
+	ActiveEnvFile = MountPath + "/.env-active"
+)
+
+var envChoice struct {
+	once        sync.Once
+	env, source string
+}
+
+// activeEnv returns the environment and where the choice came from. It is
+// worked out once per process, so git only runs once.
+func activeEnv() (string, string) {
+	envChoice.once.Do(func() { envChoice.env, envChoice.source = chooseEnv() })
+	return envChoice.env, envChoice.source
+}
+
+func chooseEnv() (string, string) {
+	if env := flagValue("--env"); env != "" { return env, "--env" }
+	if env := os.Getenv(EnvVar); env != "" { return env, "$" + EnvVar }
+	if branch := gitBranch(); branch != "" {
+		if env := branchEnv(branch); env != "" { return env, "branch " + branch }
+	}
+	if env := secCfg.Config.Environment; env != "" { return env, "secrets.yml" }
+	return DefaultEnv, "default"
+}
+
+func currentEnv() string { env, _ := activeEnv(); return env }
+
+// mappingEnv is the environment one mapping is pulled from.
+func mappingEnv(m SecretMapping) string {
+	if m.Environment != "" { return m.Environment }
+	return currentEnv()
+}
+
+// mappingPath expands {env} in a vault or sops path.
+func mappingPath(m SecretMapping) string { return strings.ReplaceAll(m.Path, "{env}", mappingEnv(m)) }
+
+// gitBranch is the branch checked out in /workspace, empty when detached or not a repository.
+func gitBranch() string {
+	out, err := exec.Command("git", "-c", "safe.directory=*", "-C", WorkspaceDir, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil { return "" }
+	branch := strings.TrimSpace(string(out))
+	if branch == "HEAD" { return "" }
+	return branch
+}
+
+// branchEnv looks the branch up in config.branches. Exact names win over
+// glob patterns such as "release/*"; among patterns the longest wins.
+func branchEnv(branch string) string {
+	if env, ok := secCfg.Config.Branches[branch]; ok { return env }
+	patterns := make([]string, 0, len(secCfg.Config.Branches))
+	for p := range secCfg.Config.Branches { patterns = append(patterns, p) }
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, branch); ok { return secCfg.Config.Branches[p] }
+	}
+	return ""
+}
+
+// pulledEnv is the environment of the last pull into this vault, if any.
+func pulledEnv() string {
+	data, err := os.ReadFile(ActiveEnvFile)
+	if err != nil { return "" }
+	return strings.TrimSpace(string(data))
+}
+
+// shellEnv is the environment the ghost shell shows in its prompt.
+func shellEnv() string {
+	if flagValue("--env") == "" {
+		if env := pulledEnv(); env != "" { return env }
+	}
+	return currentEnv()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// --- FETCHING ---
+//
+// 'pull' runs its mappings through a small worker pool (config.concurrency,
+// default 4). Every backend request gets its own deadline (config.timeout,
+// default 30s) and transient failures - network errors, timeouts, 429 and
+// 5xx answers - are retried with exponential backoff (config.retries,
+// default 3, -1 disables).
+
+const (
+	DefaultConcurrency = 4
+	DefaultRetries     = 3
+	retryBaseDelay     = 500 * time.Millisecond
+)
+
+func fetchConcurrency() int {
+	if n := secCfg.Config.Concurrency; n > 0 { return n }
+	return DefaultConcurrency
+}
+
+func requestTimeout() time.Duration {
+	if d, err := time.ParseDuration(secCfg.Config.Timeout); err == nil && d > 0 { return d }
+	return providerTimeout
+}
+
+func fetchRetries() int {
+	switch n := secCfg.Config.Retries; {
+	case n < 0: return 0
+	case n == 0: return DefaultRetries
+	default: return n
+	}
+}
+
+// temporary is implemented by backend errors that are worth retrying.
+type temporary interface{ temporary() bool }
+
+func transient(err error) bool {
+	var t temporary
+	if errors.As(err, &t) { return t.temporary() }
+	if errors.Is(err, context.DeadlineExceeded) { return true }
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// withRetry runs one backend request with its own deadline, retrying
+// transient failures until the parent context is done.
+func withRetry(ctx context.Context, what string, fn func(context.Context) error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, requestTimeout())
+		err := fn(actx)
+		cancel()
+		if err == nil || attempt >= fetchRetries() || !transient(err) || ctx.Err() != nil { return err }
+		wait := delay + time.Duration(rand.Int63n(int64(delay/2)))
+		logDebug("%s failed (%v), retry %d in %v", what, err, attempt+1, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done(): return err
+		}
+		delay *= 2
+	}
+}
+
+// memo runs each keyed fetch once, even when several mappings ask for it at
+// the same time, and remembers the result for the rest of the pull.
+type memo[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*memoCall[T]
+}
+
+type memoCall[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+func (m *memo[T]) do(key string, fn func() (T, error)) (T, error) {
+	m.mu.Lock()
+	if m.calls == nil { m.calls = map[string]*memoCall[T]{} }
+	if c, ok := m.calls[key]; ok {
+		m.mu.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := &memoCall[T]{done: make(chan struct{})}
+	m.calls[key] = c
+	m.mu.Unlock()
+	c.val, c.err = fn()
+	close(c.done)
+	return c.val, c.err
+}
+
+// forget drops a result so the next call fetches again, e.g. after a login.
+func (m *memo[T]) forget(key string) { m.mu.Lock(); delete(m.calls, key); m.mu.Unlock() }
+
+// fetchResult is one row of the pull summary.
+type fetchResult struct {
+	label, provider string
+	target          string // file in the vault, for mappings
+	optional        bool   // a failure does not abort the pull
+	value           []byte
+	err             error
+	took            time.Duration
+}
+
+// fetchAll runs fn for every job on at most fetchConcurrency() goroutines
+// and returns the results in job order.
+func fetchAll(n int, fn func(i int) fetchResult) []fetchResult {
+	results := make([]fetchResult, n)
+	sem := make(chan struct{}, fetchConcurrency())
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			start := time.Now()
+			results[i] = fn(i)
+			results[i].took = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func printSummary(results []fetchResult, took time.Duration) {
+	width := len("SECRET")
+	for _, r := range results {
+		if len(r.label) > width { width = len(r.label) }
+	}
+	fmt.Printf("\n   %-*s  %-10s  %8s  %s\n", width, "SECRET", "PROVIDER", "TIME", "RESULT")
+	failed, skipped := 0, 0
+	for _, r := range results {
+		result := "✅ OK"
+		if r.err != nil && r.optional { result = "⚠️  " + r.err.Error() + " (optional, previous kept)"; skipped++ } else if r.err != nil { result = "❌ " + r.err.Error(); failed++ }
+		fmt.Printf("   %-*s  %-10s  %8s  %s\n", width, r.label, r.provider, r.took.Round(time.Millisecond), result)
+	}
+	fmt.Printf("\n📊 %d fetched, %d failed", len(results)-failed-skipped, failed)
+	if skipped > 0 { fmt.Printf(", %d optional skipped", skipped) }
+	fmt.Printf(" in %v\n", took.Round(time.Millisecond))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// --- INFISICAL API CLIENT ---
+//
+// A small client for the parts of the Infisical REST API that 'pull' needs.
+// The base URL comes from infisical_url in secrets.yml, so EU and self-hosted
+// instances work the same way as Infisical Cloud.
+
+const DefaultInfisicalURL = "https://app.infisical.com/api"
+
+type infisicalClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// infisicalError is a non-2xx answer from the API, with the server's own message.
+type infisicalError struct {
+	Method  string
+	Path    string
+	Status  int
+	Message string
+}
+
+func (e *infisicalError) Error() string {
+	msg := fmt.Sprintf("infisical %s %s: %d %s", e.Method, e.Path, e.Status, http.StatusText(e.Status))
+	if e.Message != "" { msg += ": " + e.Message }
+	return msg
+}
+
+func (e *infisicalError) temporary() bool { return e.Status == http.StatusTooManyRequests || e.Status >= 500 }
+
+// unauthorized tells a rejected token from every other failure.
+func (e *infisicalError) unauthorized() bool { return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden }
+
+// infisicalURL is the API base without a trailing slash. A bare instance URL
+// such as https://infisical.example.com gets /api appended.
+func infisicalURL() string {
+	raw := strings.TrimRight(strings.TrimSpace(secCfg.Config.URL), "/")
+	if raw == "" { return DefaultInfisicalURL }
+	if !strings.HasSuffix(raw, "/api") { raw += "/api" }
+	return raw
+}
+
+// infisicalHost is the host the egress proxy must let through for 'pull'.
+func infisicalHost() string {
+	u, err := url.Parse(infisicalURL())
+	if err != nil || u.Hostname() == "" { return "app.infisical.com" }
+	return u.Hostname()
+}
+
+func newInfisicalClient() *infisicalClient {
+	return &infisicalClient{base: infisicalURL(), http: &http.Client{Transport: http.DefaultTransport}}
+}
+
+func (c *infisicalClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	return withRetry(ctx, "infisical "+method+" "+path, func(ctx context.Context) error { return c.doOnce(ctx, method, path, query, body, out) })
+}
+
+func (c *infisicalClient) doOnce(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil { return err }
+		payload = bytes.NewReader(data)
+	}
+	target := c.base + path
+	if len(query) > 0 { target += "?" + query.Encode() }
+	req, err := http.NewRequestWithContext(ctx, method, target, payload)
+	if err != nil { return err }
+	req.Header.Set("Accept", "application/json")
+	if body != nil { req.Header.Set("Content-Type", "application/json") }
+	if c.token != "" { req.Header.Set("Authorization", "Bearer "+c.token) }
+
+	resp, err := c.http.Do(req)
+	if err != nil { return fmt.Errorf("infisical %s %s: %w", method, path, err) }
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil { return fmt.Errorf("infisical %s %s: %w", method, path, err) }
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct{ Message interface{} `json:"message"` }
+		json.Unmarshal(data, &apiErr)
+		msg := ""
+		switch m := apiErr.Message.(type) {
+		case string: msg = m
+		case nil: msg = strings.TrimSpace(string(data))
+		default: if b, err := json.Marshal(m); err == nil { msg = string(b) }
+		}
+		if len(msg) > 200 { msg = msg[:200] + "..." }
+		return &infisicalError{Method: method, Path: path, Status: resp.StatusCode, Message: msg}
+	}
+	if out == nil { return nil }
+	if err := json.Unmarshal(data, out); err != nil { return fmt.Errorf("infisical %s %s: bad response: %w", method, path, err) }
+	return nil
+}
+
+// universalAuthLogin trades a machine identity's client credentials for an access token.
+func (c *infisicalClient) universalAuthLogin(ctx context.Context, clientID, clientSecret string) error {
+	var resp struct{ AccessToken string `json:"accessToken"` }
+	body := map[string]string{"clientId": clientID, "clientSecret": clientSecret}
+	if err := c.do(ctx, http.MethodPost, "/v1/auth/universal-auth/login", nil, body, &resp); err != nil { return err }
+	if resp.AccessToken == "" { return fmt.Errorf("infisical universal auth: empty access token") }
+	c.token = resp.AccessToken
+	return nil
+}
+
+type infisicalSecret struct {
+	Key   string `json:"secretKey"`
+	Value string `json:"secretValue"`
+}
+
+// secrets returns every secret of one environment in a single request. Values
+// from imported folders come first, so the environment's own secrets win.
+func (c *infisicalClient) secrets(ctx context.Context, projectID, environment, secretPath string) (map[string]string, error) {
+	q := url.Values{}
+	q.Set("workspaceId", projectID)
+	q.Set("environment", environment)
+	q.Set("secretPath", secretPath)
+	q.Set("include_imports", "true")
+	q.Set("expandSecretReferences", "true")
+	var resp struct {
+		Secrets []infisicalSecret `json:"secrets"`
+		Imports []struct{ Secrets []infisicalSecret `json:"secrets"` } `json:"imports"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v3/secrets/raw", q, nil, &resp); err != nil { return nil, err }
+	values := map[string]string{}
+	for _, imp := range resp.Imports {
+		for _, s := range imp.Secrets { values[s.Key] = s.Value }
+	}
+	for _, s := range resp.Secrets { values[s.Key] = s.Value }
+	return values, nil
+}
+
+// setSecret creates (POST) or updates (PATCH) one shared secret.
+func (c *infisicalClient) setSecret(ctx context.Context, method, projectID, environment, secretPath, name, value string) error {
+	body := map[string]string{"workspaceId": projectID, "environment": environment, "secretPath": secretPath, "secretValue": value, "type": "shared"}
+	return c.do(ctx, method, "/v3/secrets/raw/"+url.PathEscape(name), nil, body, nil)
+}
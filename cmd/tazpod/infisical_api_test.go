@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// standInInfisical serves the few API routes the client uses.
+func standInInfisical(t *testing.T, handler http.HandlerFunc) *infisicalClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	old := secCfg
+	t.Cleanup(func() { secCfg = old })
+	secCfg.Config.URL = srv.URL // bare instance URL, /api is appended
+	return newInfisicalClient()
+}
+
+func TestInfisicalURL(t *testing.T) {
+	old := secCfg
+	defer func() { secCfg = old }()
+	for in, want := range map[string]string{
+		"":                             DefaultInfisicalURL,
+		"https://eu.infisical.com":     "https://eu.infisical.com/api",
+		"https://eu.infisical.com/":    "https://eu.infisical.com/api",
+		"https://eu.infisical.com/api": "https://eu.infisical.com/api",
+		" https://vault.example/api/ ": "https://vault.example/api",
+	} {
+		secCfg.Config.URL = in
+		if got := infisicalURL(); got != want { t.Errorf("infisicalURL(%q) = %q, want %q", in, got, want) }
+	}
+}
+
+func TestInfisicalUniversalAuthLogin(t *testing.T) {
+	c := standInInfisical(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/auth/universal-auth/login" { http.NotFound(w, r); return }
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["clientId"] != "id" || body["clientSecret"] != "secret" { w.WriteHeader(http.StatusUnauthorized); return }
+		w.Write([]byte(`{"accessToken":"tok"}`))
+	})
+	if err := c.universalAuthLogin(context.Background(), "id", "secret"); err != nil { t.Fatal(err) }
+	if c.token != "tok" { t.Errorf("token = %q, want tok", c.token) }
+	if err := c.universalAuthLogin(context.Background(), "id", "wrong"); err == nil { t.Error("login with a wrong secret succeeded") }
+}
+
+func TestInfisicalSecretsImportPrecedence(t *testing.T) {
+	c := standInInfisical(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v3/secrets/raw" || q.Get("workspaceId") != "proj" || q.Get("environment") != "staging" || q.Get("include_imports") != "true" {
+			http.NotFound(w, r); return
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" { w.WriteHeader(http.StatusUnauthorized); return }
+		w.Write([]byte(`{
+			"secrets": [{"secretKey": "SHARED", "secretValue": "own"}, {"secretKey": "OWN", "secretValue": "1"}],
+			"imports": [{"secrets": [{"secretKey": "SHARED", "secretValue": "imported"}, {"secretKey": "IMPORTED", "secretValue": "2"}]}]
+		}`))
+	})
+	c.token = "tok"
+	values, err := c.secrets(context.Background(), "proj", "staging", "/")
+	if err != nil { t.Fatal(err) }
+	want := map[string]string{"SHARED": "own", "OWN": "1", "IMPORTED": "2"}
+	for k, v := range want {
+		if values[k] != v { t.Errorf("%s = %q, want %q", k, values[k], v) }
+	}
+	if len(values) != len(want) { t.Errorf("got %d secrets, want %d", len(values), len(want)) }
+}
+
+func TestInfisicalErrorMessage(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"message":"Project not found"}`:      "Project not found",
+		`{"message":[{"path":"environment"}]}`: `[{"path":"environment"}]`,
+		`plain text failure`:                   "plain text failure",
+	} {
+		c := standInInfisical(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(body))
+		})
+		_, err := c.secrets(context.Background(), "proj", "dev", "/")
+		var apiErr *infisicalError
+		if !errors.As(err, &apiErr) { t.Fatalf("body %s: error %v is not an infisicalError", body, err) }
+		if apiErr.Message != want { t.Errorf("body %s: message %q, want %q", body, apiErr.Message, want) }
+		if !strings.Contains(err.Error(), "400") { t.Errorf("error %q lacks the status", err) }
+	}
+}
+
+func TestInfisicalRetries(t *testing.T) {
+	old := secCfg
+	defer func() { secCfg = old }()
+	for _, tc := range []struct {
+		status       int
+		wantRequests int32
+	}{
+		{http.StatusUnauthorized, 1},       // a rejected token is final
+		{http.StatusServiceUnavailable, 2}, // one retry with config.retries 1
+	} {
+		var requests atomic.Int32
+		c := standInInfisical(t, func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(tc.status)
+		})
+		secCfg.Config.Retries = 1
+		_, err := c.secrets(context.Background(), "proj", "dev", "/")
+		var apiErr *infisicalError
+		if !errors.As(err, &apiErr) || apiErr.Status != tc.status { t.Fatalf("status %d: error %v", tc.status, err) }
+		if n := requests.Load(); n != tc.wantRequests { t.Errorf("status %d: %d requests, want %d", tc.status, n, tc.wantRequests) }
+		if apiErr.unauthorized() != (tc.status == http.StatusUnauthorized) { t.Errorf("status %d: unauthorized() = %v", tc.status, apiErr.unauthorized()) }
+	}
+
+	// A 5xx that clears up succeeds on the retry
+	var requests atomic.Int32
+	c := standInInfisical(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 { w.WriteHeader(http.StatusBadGateway); return }
+		w.Write([]byte(`{"secrets":[{"secretKey":"A","secretValue":"1"}]}`))
+	})
+	secCfg.Config.Retries = 1
+	values, err := c.secrets(context.Background(), "proj", "dev", "/")
+	if err != nil || values["A"] != "1" { t.Errorf("after a 502: %v, %v", values, err) }
+}
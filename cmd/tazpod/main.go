@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -22,24 +23,74 @@ type Config struct {
 	ContainerName string `yaml:"container_name"`
 	User          string `yaml:"user"`
 	Features      struct {
-		GhostMode bool `yaml:"ghost_mode"`
-		Debug     bool `yaml:"debug"`
+		GhostMode        bool   `yaml:"ghost_mode"`
+		Debug            bool   `yaml:"debug"`
+		GhostIdleTimeout string `yaml:"ghost_idle_timeout"` // e.g. "30m", empty disables
+		GhostMaxLifetime string `yaml:"ghost_max_lifetime"` // e.g. "8h", empty disables
+		AgentTTL         string `yaml:"agent_ttl"`          // how long 'tazpod agent' keeps keys, default 15m
 	} `yaml:"features"`
 	Build struct {
 		Dockerfile string `yaml:"dockerfile"`
 		Context    string `yaml:"context"`
 	} `yaml:"build"`
+	Vault struct {
+		Backend string `yaml:"backend"` // luks (default) or file
+		SizeMB  int    `yaml:"size_mb"`
+	} `yaml:"vault"`
+	Persist []PersistEntry `yaml:"persist"` // extra tool homes kept in the vault
+	SSH     struct {
+		Keys []SSHKey `yaml:"keys"` // empty loads every key pair in the vault
+	} `yaml:"ssh"`
+	Ghost struct {
+		Network struct {
+			Policy string   `yaml:"policy"` // host (default) or isolated
+			Allow  []string `yaml:"allow"`  // host, host:port or *.domain reachable from an isolated shell
+		} `yaml:"network"`
+	} `yaml:"ghost"`
 }
 
 type SecretMapping struct {
 	Name string `yaml:"name"`
 	File string `yaml:"file"`
 	Env  string `yaml:"env"`
+	// Provider names the backend this secret comes from (default config.provider).
+	Provider string `yaml:"provider"`
+	// Source for the vault (KV v2 path) and sops (repo file) providers; key
+	// picks one value, empty writes the whole secret.
+	Path    string `yaml:"path"`
+	Key     string `yaml:"key"`
+	Version int    `yaml:"version"`
+	// Environment pins this mapping to one environment, whatever 'pull --env' says.
+	Environment string `yaml:"environment"`
+	// Optional mappings may fail without aborting the pull; they keep their previous file.
+	Optional bool `yaml:"optional"`
+	// How the value is shaped and delivered, see mapping.go.
+	Mode     string `yaml:"mode"`     // file (default): env holds the path; env: env holds the value
+	Decode   string `yaml:"decode"`   // base64
+	JSONPath string `yaml:"jsonpath"` // field of a JSON value, "a.b[0]"
+	YAMLPath string `yaml:"yamlpath"` // field of a YAML value
+	Template string `yaml:"template"` // text/template rendered with the value
+	Perm     string `yaml:"perm"`     // octal, default 0600
+	Owner    string `yaml:"owner"`    // "user" or "user:group", default tazpod
 }
 
 type SecretsConfig struct {
 	Config struct {
 		ProjectID string `yaml:"infisical_project_id"`
+		URL       string `yaml:"infisical_url"`
+		// Universal-auth machine identity; the secret file is relative to the vault.
+		ClientID         string `yaml:"infisical_client_id"`
+		ClientSecretFile string `yaml:"infisical_client_secret_file"`
+
+		Provider    string            `yaml:"provider"`
+		Environment string            `yaml:"environment"` // default environment, see environment.go
+		Branches    map[string]string `yaml:"branches"`    // git branch (or glob) -> environment
+		Vault       VaultConfig       `yaml:"vault"`
+		Sops        SopsConfig        `yaml:"sops"`
+
+		Concurrency int    `yaml:"concurrency"` // parallel fetches, default 4
+		Timeout     string `yaml:"timeout"`     // per request, default 30s
+		Retries     int    `yaml:"retries"`     // on transient errors, default 3, -1 disables
 	} `yaml:"config"`
 	Secrets []SecretMapping `yaml:"secrets"`
 }
@@ -50,9 +101,10 @@ const (
 	VaultPath     = VaultDir + "/vault.img"
 	MountPath     = "/home/tazpod/secrets"
 	MapperName    = "tazpod_vault"
-	VaultSizeMB   = "512"
+	DefaultVaultSizeMB = 512
 	GhostEnvVar   = "TAZPOD_GHOST_MODE"
 	DebugEnvVar   = "TAZPOD_DEBUG"
+	EphemeralEnvVar = "TAZPOD_EPHEMERAL"
 	TazPodUID     = 1000
 	TazPodGID     = 1000
 	ConfigPath    = ".tazpod/config.yaml"
@@ -67,13 +119,15 @@ const (
 	InfisicalVaultDir     = MountPath + "/.infisical-vault"
 	InfisicalKeyringVault = MountPath + "/.infisical-keyring"
 	GeminiVaultDir        = MountPath + "/.gemini-vault"
-	
-	StayMarker = "/tmp/.tazpod_stay"
 )
 
 var (
 	cfg    Config
 	secCfg SecretsConfig
+
+	// Devices owned by the current ghost session, see claimVault
+	mapperName = MapperName
+	loopDevice string
 )
 
 func main() {
@@ -97,8 +151,20 @@ func main() {
 	case "env": printEnv()
 	case "__internal_env": internalPrintEnv()
 	case "unlock": unlock()
+	case "run": run()
+	case "lock": lock()
+	case "attach": attach()
+	case "internal-attach": internalAttach()
+	case "internal-netns": internalNetns()
 	case "reinit": reinit()
 	case "internal-ghost": internalGhost()
+	case "agent": agentCmd()
+	case "internal-agent": internalAgent()
+	case "vault": vaultCmd()
+	case "ssh-keys": sshKeysCmd()
+	case "secrets": secretsCmd()
+	case "push": push()
+	case "status": status()
 	default:
 		fmt.Printf("Unknown command: %s. Use 'tazpod --help'\n", arg)
 		os.Exit(1)
@@ -126,38 +192,27 @@ func help() {
 	fmt.Println("  tazpod up      -> Start the development environment")
 	fmt.Println("  tazpod down    -> Stop and remove the container")
 	fmt.Println("  tazpod ssh     -> Enter the container shell")
-	fmt.Println("  tazpod pull    -> Unlock vault and synchronize secrets")
+	fmt.Println("  tazpod pull [--env <slug>] [--ephemeral] -> Unlock vault and synchronize secrets")
+	fmt.Println("  tazpod pull --dry-run|--check -> Show what a pull would change; --check exits 4 when stale")
+	fmt.Println("  tazpod status  -> Show ghost mode, secrets environment and sessions")
 	fmt.Println("  tazpod login   -> Infisical Authentication")
 	fmt.Println("  tazpod init    -> Initialize a new TazPod project")
 	fmt.Println("  tazpod unlock  -> Manually unlock the vault (Ghost Mode)")
+	fmt.Println("  tazpod unlock --ephemeral -> RAM-only session, secrets fetched fresh and never stored")
+	fmt.Println("  tazpod lock [id|--all] -> End a ghost session and close the vault")
+	fmt.Println("  tazpod attach [id]     -> Open another shell inside a running ghost session")
+	fmt.Println("  tazpod run [--ephemeral] -- <cmd> -> Run one command inside the enclave (headless friendly)")
+	fmt.Println("  tazpod agent [--lock|--stop|--status] -> Cache the vault key across terminals for a while")
+	fmt.Println("\nNon-interactive unlock (pull, unlock, run, login):")
+	fmt.Println("  --passphrase-file <path> | --passphrase-fd <n> | $TAZPOD_PASSPHRASE | piped stdin")
 	fmt.Println("  tazpod env     -> Refresh environment variables in the shell")
-}
-
-// --- INFISICAL RUNNER ---
-
-func runInfisical(args ...string) ([]byte, error) {
-	var cmd *exec.Cmd
-	if os.Geteuid() == 0 {
-		fullArgs := append([]string{"-u", "tazpod", "infisical"}, args...)
-		cmd = exec.Command("sudo", fullArgs...)
-	} else {
-		cmd = exec.Command("infisical", args...)
-	}
-	cmd.Env = append(os.Environ(), "HOME=/home/tazpod", "USER=tazpod", "INFISICAL_VAULT_BACKEND=file")
-	return cmd.CombinedOutput()
-}
-
-func runInfisicalInteractive(args ...string) error {
-	var cmd *exec.Cmd
-	if os.Geteuid() == 0 {
-		fullArgs := append([]string{"-u", "tazpod", "infisical"}, args...)
-		cmd = exec.Command("sudo", fullArgs...)
-	} else {
-		cmd = exec.Command("infisical", args...)
-	}
-	cmd.Env = append(os.Environ(), "HOME=/home/tazpod", "USER=tazpod", "INFISICAL_VAULT_BACKEND=file")
-	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-	return cmd.Run()
+	fmt.Println("  tazpod vault export <file> -> Write an encrypted, portable copy of the vault")
+	fmt.Println("  tazpod vault import <file> -> Recreate the vault from an exported archive")
+	fmt.Println("  tazpod vault check [--repair] -> Verify LUKS header, filesystem and contents")
+	fmt.Println("  tazpod ssh-keys [list|generate <name>|add <keyfile>] -> Manage SSH keys kept in the vault")
+	fmt.Println("  tazpod secrets list [provider] -> List the secrets each configured provider can serve")
+	fmt.Println("  tazpod secrets diff -> Show which secrets changed upstream since the last pull")
+	fmt.Println("  tazpod push [name...] [--yes] [--force] -> Upload vault files back to their provider")
 }
 
 // --- LOGIC ---
@@ -204,6 +259,19 @@ user: "tazpod"
 features:
   ghost_mode: true
   debug: false
+  # ghost_idle_timeout: 30m  # lock after no keyboard input
+  # ghost_max_lifetime: 8h   # lock unconditionally after this long
+  # agent_ttl: 15m           # how long 'tazpod agent' remembers the vault key
+vault:
+  backend: luks # 'file' for hosts without loop devices or device-mapper
+# Tool homes kept inside the vault, besides Infisical and Gemini:
+# persist:
+#   - path: ~/.aws
+#   - path: ~/.kube
+#   - path: ~/.docker/config.json
+#     type: file
+#   - path: ~/.ssh
+#     mode: "0700"
 `, imageName, containerName)
 	os.WriteFile(ConfigPath, []byte(yamlContent), 0644)
 	os.MkdirAll(VaultDir, 0755)
@@ -214,6 +282,7 @@ features:
 	gitignore := `# TazPod sensitive data
 vault/
 .gemini/
+egress-denied.log
 `
 	os.WriteFile(".tazpod/.gitignore", []byte(gitignore), 0644)
 	os.MkdirAll(".gemini", 0755) 
@@ -223,6 +292,11 @@ vault/
 	secretsYAML := `# TazPod Secrets Configuration
 config:
   infisical_project_id: "your-project-id-here"
+  # provider: infisical   # default provider for mappings without 'provider:'
+  # environment: dev      # default environment for 'pull'
+  # branches:             # pick the environment from the git branch
+  #   main: prod
+  #   "release/*": staging
 
 secrets:
   # - name: KUBECONFIG_CONTENT
@@ -241,31 +315,93 @@ secrets:
 	fmt.Println("🚀 Run 'tazpod up' to start!")
 }
 
+// ghostEnv is passed through sudo to the supervisor when set: the passphrase
+// and the credentials secret providers read from the environment.
+var ghostEnv = []string{PassphraseEnvVar, EnvVar,
+	"INFISICAL_TOKEN", "INFISICAL_UNIVERSAL_AUTH_CLIENT_ID", "INFISICAL_UNIVERSAL_AUTH_CLIENT_SECRET",
+	"VAULT_ADDR", "VAULT_NAMESPACE", "VAULT_TOKEN", "VAULT_ROLE_ID", "VAULT_SECRET_ID"}
+
+// enterGhost re-executes the binary as root, it then moves itself into a
+// private mount namespace (see privateNamespace).
+func enterGhost(args ...string) error {
+	flags := passphraseArgs()
+	if env := flagValue("--env"); env != "" { flags = append(flags, "--env", env) }
+	if hasFlag("--ephemeral") { flags = append(flags, "--ephemeral") }
+	args = append(flags, args...)
+	var fullArgs []string
+	var keep []string
+	for _, name := range ghostEnv {
+		if os.Getenv(name) != "" { keep = append(keep, name) }
+	}
+	if len(keep) > 0 { fullArgs = append(fullArgs, "--preserve-env="+strings.Join(keep, ",")) }
+	fullArgs = append(fullArgs, "/usr/local/bin/tazpod", "internal-ghost")
+	cmd := exec.Command("sudo", append(fullArgs, args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// exitGhost mirrors the ghost supervisor's exit status, so the outer shell
+// wrapper can tell a normal exit from 'tazpod lock' or a failed unlock.
+func exitGhost(err error) {
+	code := exitCode(err)
+	if code == ExitLocked { fmt.Println("🔒 Vault locked.") }
+	if code != 0 { os.Exit(code) }
+}
+
+// dryRun reports whether 'pull' should only compare, see diff.go.
+func dryRun() bool { return hasFlag("--dry-run") || hasFlag("--check") }
+
+func exitCode(err error) int {
+	if err == nil { return 0 }
+	if ee, ok := err.(*exec.ExitError); ok { return ee.ExitCode() }
+	return 1
+}
+
 func pull() {
 	if os.Getenv(GhostEnvVar) != "true" {
-		fmt.Println("👻 Vault closed. Starting auto-unlock & pull...")
-		cmd := exec.Command("sudo", "unshare", "--mount", "--propagation", "private", "/usr/local/bin/tazpod", "internal-ghost", "pull")
-		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-		cmd.Run()
+		args := []string{"pull"}
+		if dryRun() {
+			fmt.Println("👻 Vault closed. Unlocking to compare secrets...")
+			for _, f := range []string{"--dry-run", "--check"} { if hasFlag(f) { args = append(args, f) } }
+		} else {
+			fmt.Println("👻 Vault closed. Starting auto-unlock & pull...")
+		}
+		exitGhost(enterGhost(args...))
 		return
 	}
-	internalEnsureAuth(); syncSecrets()
+	if dryRun() { os.Exit(diffSecrets(hasFlag("--check"))) }
+	internalEnsureAuth()
+	if err := syncSecrets(); err != nil { os.Exit(1) }
 }
 
 func unlock() {
 	if os.Getenv(GhostEnvVar) == "true" { fmt.Println("✅ Already in Ghost Mode."); return }
+	if hasFlag("--ephemeral") {
+		fmt.Println("👻 Entering Ephemeral Ghost Mode (RAM only)...")
+		exitGhost(enterGhost("pull"))
+		return
+	}
 	fmt.Println("👻 Entering Ghost Mode...")
-	cmd := exec.Command("sudo", "unshare", "--mount", "--propagation", "private", "/usr/local/bin/tazpod", "internal-ghost")
-	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-	cmd.Run()
+	exitGhost(enterGhost())
+}
+
+// run executes a single command inside the ghost enclave and returns its exit
+// status, without an interactive shell. Meant for CI and editor tasks.
+func run() {
+	command := commandArgs()
+	if len(command) == 0 { fmt.Println("Usage: tazpod run [--ephemeral] [--passphrase-file <f>|--passphrase-fd <n>] -- <command> [args...]"); os.Exit(1) }
+	if os.Getenv(GhostEnvVar) == "true" {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		os.Exit(exitCode(cmd.Run()))
+	}
+	os.Exit(exitCode(enterGhost(append([]string{"run", "--"}, command...)...)))
 }
 
 func login() {
 	if os.Getenv(GhostEnvVar) != "true" {
 		fmt.Println("👻 Vault closed. Opening enclave for login...")
-		cmd := exec.Command("sudo", "unshare", "--mount", "--propagation", "private", "/usr/local/bin/tazpod", "internal-ghost", "login")
-		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-		cmd.Run()
+		exitGhost(enterGhost("login"))
 		return
 	}
 	internalLogin()
@@ -282,23 +418,65 @@ func internalLogin() {
 }
 
 func internalGhost() {
+	takeEnvPassphrase()
+	if err := privateNamespace(); err != nil { fmt.Printf("❌ Cannot enter private namespace: %v\n", err); os.Exit(1) }
 	os.Setenv(GhostEnvVar, "true")
 	if cfg.Features.Debug { os.Setenv(DebugEnvVar, "true") }
 
+	args := positional()
 	requestedCmd := ""
-	if len(os.Args) > 2 { requestedCmd = os.Args[2] }
-
-	passphrase := performUnlock()
+	if len(args) > 0 { requestedCmd = args[0] }
+	if hasFlag("--ephemeral") { activeBackend = ramBackend{}; os.Setenv(EphemeralEnvVar, "true") }
+
+	sess, err := startSession()
+	if err != nil { logDebug("Session registry unavailable, 'tazpod lock' will not reach this shell: %v", err) }
+	currentSession = sess
+	reapSessions()
+	if os.Getenv(EphemeralEnvVar) != "true" { claimVault(sess) }
+	sup := supervise(sess)
+	defer sup.recoverPanic()
+
+	var imported []byte
+	if requestedCmd == "vault-check" { internalVaultCheck(hasFlag("--repair")); sess.close(); return }
+	if requestedCmd == "vault-import" && len(args) > 1 { imported = internalVaultImport(args[1]) }
+
+	passphrase, fromAgent := "", false
+	if os.Getenv(EphemeralEnvVar) != "true" && !isMounted(MountPath) {
+		if kc, ok := backend().(keyCache); ok && backend().Exists() {
+			if key := agentGet(); key != nil { kc.UseVolumeKey(key); fromAgent = true; fmt.Println("🗝️  Vault key provided by agent.") }
+		}
+		if !fromAgent { passphrase = performUnlock() }
+	}
 	
 	fmt.Println("🚀 Mounting secure vault...")
-	mountVault(passphrase)
+	backend().Mount(passphrase)
+	if kc, ok := backend().(keyCache); ok && passphrase != "" && agentRunning() { agentPut(kc.VolumeKey(passphrase)) }
+
+	if imported != nil {
+		fmt.Println("📥 Restoring archive contents...")
+		if err := unpackDir(imported, MountPath, TazPodUID, TazPodGID); err != nil {
+			// A half-filled vault must not open: drop it so 'vault import' restores the previous one
+			fmt.Printf("❌ Restore failed: %v\n", err)
+			sup.teardown()
+			os.Remove(backend().Path())
+			os.Exit(1)
+		}
+		fmt.Println("✅ Vault imported.")
+	}
 	
 	fmt.Println("🔑 Restoring Infisical enclave identity...")
 	migrateLegacyAuth()
 	setupBindAuth()
 
-	if requestedCmd == "pull" {
-		internalEnsureAuth(); syncSecrets()
+	// An ephemeral vault starts empty, so 'run --ephemeral' pulls first
+	ephemeralRun := requestedCmd == "run" && os.Getenv(EphemeralEnvVar) == "true"
+	var pullErr error
+	if requestedCmd == "pull" && dryRun() {
+		code := diffSecrets(hasFlag("--check"))
+		sup.teardown()
+		os.Exit(code)
+	} else if requestedCmd == "pull" || ephemeralRun {
+		internalEnsureAuth(); pullErr = syncSecrets()
 	} else if requestedCmd == "login" {
 		internalLogin()
 	}
@@ -308,48 +486,107 @@ func internalGhost() {
 		fmt.Println("✅ Infisical session restored successfully.")
 	}
 
-	fmt.Println("\n✨ TAZPOD GHOST MODE ACTIVE.")
+	if ephemeralRun && pullErr != nil { fmt.Println("❌ Not running the command without its secrets."); sup.teardown(); os.Exit(1) }
+	if requestedCmd != "run" { fmt.Println("\n✨ TAZPOD GHOST MODE ACTIVE.") }
 	
-bashCmd := exec.Command("bash")
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	if requestedCmd == "pull" && !interactive {
+		fmt.Println("ℹ️  No terminal attached, skipping the ghost shell.")
+		sup.teardown()
+		if pullErr != nil { os.Exit(1) }
+		return
+	}
+
+	shellArgs := []string{"bash"}
+	if requestedCmd == "run" { shellArgs = commandArgs() }
+bashCmd := exec.Command(shellArgs[0], shellArgs[1:]...)
 bashCmd.Stdin, bashCmd.Stdout, bashCmd.Stderr = os.Stdin, os.Stdout, os.Stderr
 bashCmd.SysProcAttr = &syscall.SysProcAttr{ Credential: &syscall.Credential{Uid: uint32(TazPodUID), Gid: uint32(TazPodGID)} }
 	
 	newEnv := os.Environ()
-	newEnv = append(newEnv, GhostEnvVar+"=true", "USER=tazpod", "HOME=/home/tazpod", "INFISICAL_VAULT_BACKEND=file")
+	newEnv = append(newEnv, GhostEnvVar+"=true", "USER=tazpod", "HOME=/home/tazpod", "INFISICAL_VAULT_BACKEND=file", PromptEnvVar+"="+shellEnv())
 	
 	if len(secCfg.Secrets) > 0 {
 		fmt.Println("📦 Loading environment secrets...")
 		for _, s := range secCfg.Secrets {
-			if s.Env != "" {
-				target := filepath.Join(MountPath, s.File)
-				if _, err := os.Stat(target); err == nil {
-					fmt.Printf("  ✅ Setting %s -> %s\n", s.Env, s.File)
-					newEnv = append(newEnv, fmt.Sprintf("%s=%s", s.Env, target))
-				} else {
-					fmt.Printf("  ⚠️  Skipping %s (File %s not found)\n", s.Env, s.File)
-				}
+			if s.Env == "" { continue }
+			if val, err := mappingShellValue(s); err == nil {
+				if s.Mode == "env" { fmt.Printf("  ✅ Setting %s (value of %s)\n", s.Env, mappingFile(s)) } else { fmt.Printf("  ✅ Setting %s -> %s\n", s.Env, mappingFile(s)) }
+				newEnv = append(newEnv, s.Env+"="+val)
+			} else {
+				fmt.Printf("  ⚠️  Skipping %s (File %s not found)\n", s.Env, mappingFile(s))
 			}
 		}
 	}
 
 	if data, err := os.ReadFile(EnvFile); err == nil {
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "export ") {
-				kv := strings.TrimPrefix(line, "export "); parts := strings.SplitN(kv, "=", 2)
-				if len(parts) == 2 { newEnv = append(newEnv, fmt.Sprintf("%s=%s", parts[0], strings.Trim(parts[1], "'\"")))}
-			}
+		for k, v := range parseExports(data) { newEnv = append(newEnv, k+"="+v) }
+	}
+	if a, err := startSSHAgent(); err != nil {
+		fmt.Printf("⚠️  SSH agent unavailable: %v\n", err)
+	} else if a != nil {
+		ghostSSH = a
+		newEnv = append(newEnv, "SSH_AUTH_SOCK="+a.socket)
+	}
+	if sess != nil { newEnv = append(newEnv, SessionEnvVar+"="+sess.ID); sess.setEnv(newEnv); sess.Env = shellEnv(); sess.save() }
+
+	var locked atomic.Bool
+	status := 0
+	bashCmd.Env = newEnv
+	if networkIsolated() {
+		// Fail closed: a shell that was meant to be isolated must not start without it
+		p, err := startEgressProxy(sess)
+		if err == nil {
+			ghostProxy = p
+			bashCmd.Env = append(bashCmd.Env, proxyEnv()...)
+			if sess != nil { sess.setEnv(bashCmd.Env) }
+			bashCmd, err = isolateCommand(bashCmd, p.socket)
+		}
+		if err != nil { fmt.Printf("❌ Network isolation unavailable: %v\n", err); sup.teardown(); os.Exit(1) }
+		fmt.Printf("🛡️  Network isolated, egress limited to: %s\n", strings.Join(p.allow, ", "))
+	}
+	done := make(chan struct{}) // the primary shell has exited
+	live := make(chan struct{}) // every shell of the session has exited
+	if err := sup.startShell(bashCmd, done); err != nil {
+		fmt.Printf("❌ Cannot start %s: %v\n", shellArgs[0], err); status = 127
+	} else {
+		if ghostProxy != nil && sess != nil { sess.NetPID = bashCmd.Process.Pid; sess.save() }
+		lockSession := func() {
+			locked.Store(true)
+			if sess != nil { sess.lockAttached() }
+			terminateShell(bashCmd.Process, done)
+		}
+		// Lock requests and the limits stay armed while attached shells outlive the primary one
+		watchSession(live, lockSession)
+		if sess != nil {
+			go func() {
+				select {
+				case <-sess.lockReq: lockSession()
+				case <-live:
+				}
+			}()
 		}
+		status = exitCode(bashCmd.Wait()); close(done)
 	}
-	bashCmd.Env = newEnv; bashCmd.Run()
+	sess.waitAttached()
+	close(live)
 
+	sup.teardown()
+	if locked.Load() { os.Exit(ExitLocked) }
+	if requestedCmd == "run" { os.Exit(status) }
+}
+
+// teardownGhost hides the vault again: bridges first, then the vault itself.
+func teardownGhost(sess *session) {
 	logDebug("Locking Ghost Enclave...")
-	exec.Command("umount", "-l", InfisicalKeyringLocal).Run()
-	exec.Command("umount", "-l", InfisicalLocalHome).Run()
-	exec.Command("umount", "-l", GeminiLocalHome).Run()
-	exec.Command("umount", "-l", MountPath).Run()
-	cleanupMappers()
+	if _, ok := backend().(ramBackend); !ok && isMounted(MountPath) {
+		if err := writeManifest(); err != nil { logDebug("Manifest not written: %v", err) }
+	}
+	ghostSSH.stop()
+	ghostProxy.stop()
+	unbridgeAll(sess)
+	backend().Unmount()
+	sess.close()
 }
 
 func migrateLegacyAuth() {
@@ -357,70 +594,42 @@ func migrateLegacyAuth() {
 	for _, old := range legacyPaths {
 		if old == InfisicalVaultDir { continue }
 		if _, err := os.Stat(old); err == nil {
-			if _, errDir := os.Stat(InfisicalVaultDir); os.IsNotExist(errDir) { exec.Command("mv", old, InfisicalVaultDir).Run() } else { exec.Command("rm", "-rf", old).Run() }
+			if _, errDir := os.Stat(InfisicalVaultDir); os.IsNotExist(errDir) { os.Rename(old, InfisicalVaultDir) } else { os.RemoveAll(old) }
 		}
 	}
 }
 
-func setupBindAuth() {
-	bridge(InfisicalLocalHome, InfisicalVaultDir)
-	bridge(InfisicalKeyringLocal, InfisicalKeyringVault)
-	bridge(GeminiLocalHome, GeminiVaultDir)
-}
-
-func bridge(local, vault string) {
-	out, _ := exec.Command("mount").Output()
-	if strings.Contains(string(out), local) { return }
-	os.MkdirAll(vault, 0755); exec.Command("chown", "-R", "tazpod:tazpod", vault).Run()
-	exec.Command("rm", "-rf", local).Run(); os.MkdirAll(local, 0755)
-	if err := exec.Command("mount", "--bind", vault, local).Run(); err != nil {
-		logDebug("Mount bind failed for %s: %v", local, err)
-	}
-	exec.Command("chown", "-R", "tazpod:tazpod", local).Run()
-}
-
-func syncSecrets() {
-	fmt.Println("📦 Syncing secrets...")
-	pID := secCfg.Config.ProjectID
-	args := []string{"export", "--format=dotenv", "--silent"}
-	if pID != "" { args = append(args, "--projectId", pID) }
-	args = append(args, "--env", "dev")
-	out, err := runInfisical(args...)
-	if err == nil && len(out) > 0 { os.WriteFile(EnvFile, out, 0600); os.Chown(EnvFile, TazPodUID, TazPodGID) }
-	for _, s := range secCfg.Secrets {
-		target := filepath.Join(MountPath, s.File)
-		fmt.Printf("⬇️  Pulling [%s] -> [%s]... ", s.Name, s.File)
-		cmdArgs := []string{"secrets", "get", s.Name, "--plain"}
-		if pID != "" { cmdArgs = append(cmdArgs, "--projectId", pID) }
-		cmdArgs = append(cmdArgs, "--env", "dev")
-		val, err := runInfisical(cmdArgs...)
-		if err == nil && len(strings.TrimSpace(string(val))) > 0 { os.WriteFile(target, val, 0600); os.Chown(target, TazPodUID, TazPodGID); fmt.Println("✅ OK") } else { fmt.Println("❌ FAILED") }
-	}
-}
-
 func printEnv() { fmt.Println("🔄 Enclave environment variables refreshed.") }
 
 func internalPrintEnv() {
 	if term.IsTerminal(int(os.Stdout.Fd())) { fmt.Fprintln(os.Stderr, "❌ Security Error"); os.Exit(1) }
 	if data, err := os.ReadFile(EnvFile); err == nil { fmt.Print(string(data)) }
+	if env := pulledEnv(); env != "" { fmt.Printf("export %s='%s'\n", PromptEnvVar, env) }
 	for _, s := range secCfg.Secrets {
-		if s.Env != "" {
-			target := filepath.Join(MountPath, s.File)
-			if _, err := os.Stat(target); err == nil { fmt.Printf("export %s='%s'\n", s.Env, target) } else { fmt.Printf("unset %s\n", s.Env) }
-		}
+		if s.Env == "" { continue }
+		if val, err := mappingShellValue(s); err == nil { fmt.Printf("export %s=%s\n", s.Env, shellQuote(val)) } else { fmt.Printf("unset %s\n", s.Env) }
 	}
 }
 
-func internalEnsureAuth() {
-	configPath := filepath.Join(InfisicalLocalHome, "infisical-config.json")
-	if _, err := os.Stat(configPath); os.IsNotExist(err) { internalLogin(); return }
-	pID := secCfg.Config.ProjectID
-	args := []string{"secrets", "--env", "dev", "--silent"}
-	if pID != "" { args = append(args, "--projectId", pID) }
-	if _, err := runInfisical(args...); err != nil { internalLogin() }
+func mountVault(passphrase string, volumeKey []byte) {
+	mapper := "/dev/mapper/" + mapperName
+	if openVault(passphrase, volumeKey) { runCmd("mkfs.ext4", "-q", mapper) } else if !isMounted(MountPath) && !runFsck(mapper, "-p") {
+		fmt.Println("❌ Vault filesystem is damaged. Run 'tazpod vault check --repair'.")
+		cleanupMappers(); os.Exit(1)
+	}
+	if !isMounted(MountPath) {
+		os.MkdirAll(MountPath, 0755)
+		if err := mountFS(mapper, MountPath, "ext4", "rw,nosuid,nodev"); err != nil {
+			fmt.Printf("❌ Cannot mount vault: %v\n", err); cleanupMappers(); os.Exit(1)
+		}
+	}
+	if err := chownR(MountPath, TazPodUID, TazPodGID); err != nil { fmt.Printf("⚠️  %v\n", err) }
 }
 
-func mountVault(passphrase string) {
+// openVault attaches the image and opens the LUKS mapper, creating and
+// formatting the image first if needed. It reports whether the vault is new.
+// A volume key from the agent replaces the passphrase when it is still valid.
+func openVault(passphrase string, volumeKey []byte) bool {
 	// LEGACY MIGRATION (v9.3)
 	oldVaultPath := "/workspace/.tazpod-vault/vault.img"
 	if _, err := os.Stat(oldVaultPath); err == nil {
@@ -430,59 +639,72 @@ func mountVault(passphrase string) {
 		os.Remove("/workspace/.tazpod-vault")
 	}
 
-	ensureNodes(); cleanupMappers()
-	logDebug("Cleaning loop devices...")
-	exec.Command("bash", "-c", "losetup -a | grep 'vault.img' | cut -d: -f1 | xargs -r sudo losetup -d").Run()
+	if err := ensureNodes(); err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
 
 	isNew := false
 	if !fileExist(VaultPath) { 
 		isNew = true; 
 		logDebug("Creating new vault image...")
 		os.MkdirAll(VaultDir, 0755)
-		runCmd("dd", "if=/dev/zero", "of="+VaultPath, "bs=1M", "count="+VaultSizeMB, "status=none") 
-		exec.Command("chown", "-R", "tazpod:tazpod", ".tazpod").Run() // Ensure image file ownership
+		if err := allocateFile(VaultPath, int64(vaultSizeMB())<<20); err != nil { fmt.Printf("❌ Cannot create vault image: %v\n", err); os.Exit(1) }
+		os.Chown(VaultPath, TazPodUID, TazPodGID) // Ensure image file ownership
 	}
-	loopDev := runOutput("losetup", "-f", "--show", VaultPath)
+	loopDev, err := attachLoop(VaultPath)
+	if err != nil { fmt.Printf("❌ Cannot attach vault image: %v\n", err); os.Exit(1) }
+	loopDevice = loopDev
+	if currentSession != nil { currentSession.Loop = loopDev; currentSession.save() }
 	if isNew { runWithStdin(passphrase, "cryptsetup", "luksFormat", "--batch-mode", "--key-file", "-", loopDev) }
 	
-	if _, err := os.Stat("/dev/mapper/" + MapperName); os.IsNotExist(err) {
-		if _, err := runWithStdin(passphrase, "cryptsetup", "open", "--key-file", "-", loopDev, MapperName); err != nil { os.Exit(1) }
+	if _, err := os.Stat("/dev/mapper/" + mapperName); os.IsNotExist(err) {
+		if volumeKey == nil || !openWithVolumeKey(loopDev, volumeKey) {
+			if volumeKey != nil { fmt.Println("⚠️  Cached vault key was rejected."); passphrase = readPassphrase(false) }
+			if _, err := runWithStdin(passphrase, "cryptsetup", "open", "--key-file", "-", loopDev, mapperName); err != nil { fmt.Println("❌ DECRYPTION FAILED."); cleanupMappers(); os.Exit(1) }
+		}
 	}
-	exec.Command("dmsetup", "mknodes").Run()
-	waitForDevice("/dev/mapper/" + MapperName)
-	if isNew { runCmd("mkfs.ext4", "-q", "/dev/mapper/"+MapperName) }
-	if !isMounted(MountPath) { os.MkdirAll(MountPath, 0755); exec.Command("mount", "-o", "rw", "-t", "ext4", "/dev/mapper/"+MapperName, MountPath).Run() }
-	exec.Command("chown", "-R", "tazpod:tazpod", MountPath).Run()
+	if err := dmNode(mapperName); err != nil { logDebug("%v", err) }
+	waitForDevice("/dev/mapper/" + mapperName)
+	return isNew
 }
 
-func isMounted(path string) bool { data, _ := os.ReadFile("/proc/mounts"); return strings.Contains(string(data), path) }
+func vaultSizeMB() int { if cfg.Vault.SizeMB > 0 { return cfg.Vault.SizeMB }; return DefaultVaultSizeMB }
+
+func isMounted(path string) bool {
+	data, _ := os.ReadFile("/proc/mounts")
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == path { return true }
+	}
+	return false
+}
 
 func performUnlock() string {
 	if isMounted(MountPath) { return "" }
-	var passphrase string
-	if !fileExist(VaultPath) {
-		fmt.Println("🆕 Creating new vault..."); for {
-			fmt.Print("📝 Define Passphrase: "); p1, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
-			fmt.Print("📝 Confirm: "); p2, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
-			if string(p1) == string(p2) && len(p1) > 0 { passphrase = string(p1); break }
-		}
-	} else {
-		fmt.Print("🔑 Enter Passphrase: "); p, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println(); passphrase = string(p)
-	}
-	return passphrase
+	newVault := !backend().Exists()
+	if newVault { fmt.Println("🆕 Creating new vault...") }
+	return readPassphrase(newVault)
 }
 
+// cleanupMappers closes the mapper and detaches the loop device of the current
+// session, and nothing else: other sessions keep their own devices.
 func cleanupMappers() {
-	if exec.Command("dmsetup", "info", MapperName).Run() == nil {
-		exec.Command("cryptsetup", "close", MapperName).Run()
-		if exec.Command("dmsetup", "info", MapperName).Run() == nil { exec.Command("dmsetup", "remove", "--force", MapperName).Run() }
+	closeMapper(mapperName)
+	if loopDevice == "" { return }
+	if err := detachLoop(loopDevice); err != nil { logDebug("%v", err) }
+	loopDevice = ""
+}
+
+func closeMapper(name string) {
+	if !dmExists(name) { return }
+	exec.Command("cryptsetup", "close", name).Run()
+	if dmExists(name) {
+		if err := dmRemove(name); err != nil { logDebug("%v", err) }
 	}
+	os.Remove("/dev/mapper/" + name)
 }
 
 func reinit() {
 	if os.Getenv(GhostEnvVar) == "true" { fmt.Println("❌ Exit Ghost Mode first."); os.Exit(1) }
 	fmt.Print("⚠️  WIPE VAULT? (y/N): "); var c string; fmt.Scanln(&c)
-	if strings.ToLower(c) == "y" { os.Remove(VaultPath); pull() }
+	if strings.ToLower(c) == "y" { os.Remove(backend().Path()); pull() }
 }
 
 func runCmd(name string, args ...string) { cmd := exec.Command(name, args...); cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr; cmd.Run() }
@@ -492,12 +714,43 @@ func runWithStdin(input, name string, args ...string) (string, error) {
 	cmd := exec.Command(name, args...); cmd.Stdin = bytes.NewBufferString(input); var out, stderr bytes.Buffer
 	cmd.Stdout, cmd.Stderr = &out, &stderr; err := cmd.Run(); return out.String(), err
 }
-func fileExist(path string) bool { _, err := os.Stat(path); return err == nil }
-func ensureNodes() {
-	exec.Command("sudo", "mknod", "/dev/loop-control", "c", "10", "237").Run()
-	for i := 0; i < 64; i++ { exec.Command("sudo", "mknod", fmt.Sprintf("/dev/loop%d", i), "b", "7", fmt.Sprintf("%d", i)).Run() }
-	exec.Command("sudo", "dmsetup", "mknodes").Run()
+// valueFlags consume the argument that follows them.
+var valueFlags = map[string]bool{"--passphrase-file": true, "--passphrase-fd": true, "--comment": true, "--name": true, "--env": true}
+
+// cliArgs returns the arguments after the command, up to a "--" separator.
+func cliArgs() []string {
+	for i, a := range os.Args[2:] { if a == "--" { return os.Args[2 : 2+i] } }
+	return os.Args[2:]
 }
+
+// commandArgs returns everything after the "--" separator.
+func commandArgs() []string {
+	for i, a := range os.Args { if a == "--" { return os.Args[i+1:] } }
+	return nil
+}
+
+// hasFlag reports whether a --flag was given anywhere after the command.
+func hasFlag(name string) bool { for _, a := range cliArgs() { if a == name { return true } }; return false }
+
+// flagValue returns the argument following a value flag, or "".
+func flagValue(name string) string {
+	args := cliArgs()
+	for i, a := range args { if a == name && i+1 < len(args) { return args[i+1] } }
+	return ""
+}
+
+// positional returns the arguments after the command with flags removed.
+func positional() []string {
+	var out []string
+	args := cliArgs()
+	for i := 0; i < len(args); i++ {
+		if valueFlags[args[i]] { i++; continue }
+		if !strings.HasPrefix(args[i], "--") { out = append(out, args[i]) }
+	}
+	return out
+}
+
+func fileExist(path string) bool { _, err := os.Stat(path); return err == nil }
 func waitForDevice(path string) { for i:=0; i<20; i++ { if fileExist(path) { return }; time.Sleep(200*time.Millisecond) } }
 func up() {
 	fmt.Printf("🏗️  TazPod Up [%s]...\n", cfg.ContainerName)
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// --- SECRET MAPPINGS ---
+//
+// A fetched value goes through, in order: 'decode: base64', then 'jsonpath'
+// or 'yamlpath' picking one field ("a.b[0].c"), then 'template' rendering
+// it (the fields of a JSON or YAML value are {{.field}}, a plain value is
+// {{.}}). The result is written to 'file' with 'perm' and 'owner', creating
+// nested directories. 'env' gets the file path, or with 'mode: env' the
+// value itself; such mappings may leave out 'file' and are kept under
+// EnvValuesDir.
+
+// EnvValuesDir holds 'mode: env' values that have no file of their own.
+const EnvValuesDir = ".env.d"
+
+// mappingSpec is a SecretMapping resolved to a path and numbers.
+type mappingSpec struct {
+	target   string
+	perm     os.FileMode
+	uid, gid int
+	owner    bool // an explicit owner, failing to apply it is an error
+	envValue bool // 'env' holds the value instead of the path
+}
+
+// mappingFile is the mapping's file relative to the vault.
+func mappingFile(m SecretMapping) string {
+	if m.File == "" && m.Mode == "env" && m.Env != "" { return filepath.Join(EnvValuesDir, m.Env) }
+	return m.File
+}
+
+func resolveMapping(m SecretMapping) (mappingSpec, error) {
+	s := mappingSpec{perm: 0600, uid: TazPodUID, gid: TazPodGID, envValue: m.Mode == "env"}
+	if m.Mode != "" && m.Mode != "file" && m.Mode != "env" { return s, fmt.Errorf("mode must be file or env") }
+	if s.envValue && m.Env == "" { return s, fmt.Errorf("mode env needs env") }
+	if m.Decode != "" && m.Decode != "base64" { return s, fmt.Errorf("unknown decode %q (use base64)", m.Decode) }
+	if m.JSONPath != "" && m.YAMLPath != "" { return s, fmt.Errorf("use jsonpath or yamlpath, not both") }
+
+	file := mappingFile(m)
+	if file == "" { return s, fmt.Errorf("no file") }
+	s.target = filepath.Join(MountPath, file)
+	if !strings.HasPrefix(s.target, MountPath+"/") || strings.HasPrefix(strings.TrimPrefix(s.target, MountPath+"/"), StagingPrefix) {
+		return s, fmt.Errorf("file %s is outside the vault", file)
+	}
+	if m.Perm != "" {
+		p, err := strconv.ParseUint(m.Perm, 8, 32)
+		if err != nil || p > 0777 { return s, fmt.Errorf("invalid perm %q", m.Perm) }
+		s.perm = os.FileMode(p)
+	}
+	if m.Owner != "" {
+		uid, gid, err := lookupOwner(m.Owner)
+		if err != nil { return s, err }
+		s.uid, s.gid, s.owner = uid, gid, true
+	}
+	return s, nil
+}
+
+// derived reports whether the file is computed from the upstream value in a
+// way that cannot be reversed for 'push'.
+func (m SecretMapping) derived() bool { return m.JSONPath != "" || m.YAMLPath != "" || m.Template != "" }
+
+// renderMapping turns a fetched value into the file contents.
+func renderMapping(m SecretMapping, value []byte) ([]byte, error) {
+	if m.Decode == "base64" {
+		out, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(value)))
+		if err != nil { return nil, fmt.Errorf("base64: %w", err) }
+		value = out
+	}
+	var err error
+	switch {
+	case m.JSONPath != "":
+		var doc interface{}
+		if err := json.Unmarshal(value, &doc); err != nil { return nil, fmt.Errorf("jsonpath: value is not JSON: %w", err) }
+		if doc, err = walkPath(doc, m.JSONPath); err != nil { return nil, err }
+		if s, ok := doc.(string); ok { value = []byte(s) } else if value, err = json.Marshal(doc); err != nil { return nil, err }
+	case m.YAMLPath != "":
+		var doc interface{}
+		if err := yaml.Unmarshal(value, &doc); err != nil { return nil, fmt.Errorf("yamlpath: value is not YAML: %w", err) }
+		if doc, err = walkPath(doc, m.YAMLPath); err != nil { return nil, err }
+		if s, ok := doc.(string); ok { value = []byte(s) } else if value, err = yaml.Marshal(doc); err != nil { return nil, err }
+	}
+	if m.Template != "" {
+		tmpl, err := template.New(m.Name).Option("missingkey=error").Funcs(template.FuncMap{
+			"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+			"b64dec": func(s string) (string, error) { b, err := base64.StdEncoding.DecodeString(s); return string(b), err },
+		}).Parse(m.Template)
+		if err != nil { return nil, fmt.Errorf("template: %w", err) }
+		var data interface{} = string(value)
+		var doc interface{}
+		if yaml.Unmarshal(value, &doc) == nil {
+			if obj, ok := doc.(map[string]interface{}); ok { data = obj }
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil { return nil, fmt.Errorf("template: %w", err) }
+		value = b.Bytes()
+	}
+	return value, nil
+}
+
+// unrenderMapping turns file contents back into the upstream value for 'push'.
+func unrenderMapping(m SecretMapping, local []byte) ([]byte, error) {
+	if m.derived() { return nil, fmt.Errorf("file is derived with jsonpath, yamlpath or template") }
+	if m.Decode == "base64" { return []byte(base64.StdEncoding.EncodeToString(local)), nil }
+	return local, nil
+}
+
+// walkPath follows "a.b[0].c" (a leading "$" or "." is ignored) into a
+// decoded JSON or YAML document.
+func walkPath(doc interface{}, path string) (interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" { return doc, nil }
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			obj, ok := doc.(map[string]interface{})
+			if ok { doc, ok = obj[name] }
+			if !ok { return nil, fmt.Errorf("no field %s in %s", name, path) }
+		}
+		for rest != "" {
+			idx, after, ok := strings.Cut(rest, "]")
+			n, err := strconv.Atoi(idx)
+			list, isList := doc.([]interface{})
+			if !ok || err != nil || !isList || n < 0 || n >= len(list) { return nil, fmt.Errorf("no index [%s] in %s", idx, path) }
+			doc, rest = list[n], strings.TrimPrefix(after, "[")
+		}
+	}
+	return doc, nil
+}
+
+// mappingShellValue is what the mapping puts in its env var: the file path,
+// or the contents without the trailing newline for 'mode: env'.
+func mappingShellValue(m SecretMapping) (string, error) {
+	s, err := resolveMapping(m)
+	if err != nil { return "", err }
+	if !s.envValue {
+		if _, err := os.Stat(s.target); err != nil { return "", err }
+		return s.target, nil
+	}
+	data, err := os.ReadFile(s.target)
+	if err != nil { return "", err }
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isolateCommand wraps the ghost shell in internal-netns, started in a new
+// network namespace, which brings up loopback, serves the proxy relay and then
+// runs the shell. The helper's PID names the namespace for attach.
+func isolateCommand(cmd *exec.Cmd, socket string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil { return nil, err }
+	wrapped := exec.Command(self, append([]string{"internal-netns", socket, "--"}, cmd.Args...)...)
+	wrapped.Env = cmd.Env
+	wrapped.Stdin, wrapped.Stdout, wrapped.Stderr = cmd.Stdin, cmd.Stdout, cmd.Stderr
+	wrapped.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+	return wrapped, nil
+}
+
+func internalNetns() {
+	if os.Geteuid() != 0 { fmt.Println("❌ internal-netns must run as root."); os.Exit(1) }
+	args, command := positional(), commandArgs()
+	if len(args) == 0 || len(command) == 0 { os.Exit(1) }
+	if err := loopbackUp(); err != nil { fmt.Printf("❌ Cannot bring up loopback: %v\n", err); os.Exit(1) }
+	l, err := net.Listen("tcp", EgressProxyAddr)
+	if err != nil { fmt.Printf("❌ Cannot start egress relay: %v\n", err); os.Exit(1) }
+	go forwardToProxy(l, args[0])
+
+	shell := exec.Command(command[0], command[1:]...)
+	shell.Stdin, shell.Stdout, shell.Stderr = os.Stdin, os.Stdout, os.Stderr
+	shell.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(TazPodUID), Gid: uint32(TazPodGID)}}
+	if err := relayChild(shell); err != nil { fmt.Printf("❌ Cannot start %s: %v\n", command[0], err); os.Exit(127) }
+}
+
+// loopbackUp sets IFF_UP on lo, the only interface of a fresh namespace.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil { return &sysError{"socket", "lo", err} }
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil { return &sysError{"ifreq", "lo", err} }
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil { return &sysError{"SIOCGIFFLAGS", "lo", err} }
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil { return &sysError{"SIOCSIFFLAGS", "lo", err} }
+	return nil
+}
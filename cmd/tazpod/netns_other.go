@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Network namespaces only exist inside the Linux container.
+
+func isolateCommand(*exec.Cmd, string) (*exec.Cmd, error) { return nil, errUnsupported }
+
+func internalNetns() { fmt.Println("❌ internal-netns needs Linux."); os.Exit(1) }
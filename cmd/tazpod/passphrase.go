@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// --- NON-INTERACTIVE UNLOCK ---
+//
+// Passphrase sources, in order: --passphrase-file, --passphrase-fd, the
+// TAZPOD_PASSPHRASE variable, piped stdin, and finally the TTY prompt.
+// sudo closes extra descriptors and resets the environment, so the outer
+// process resolves --passphrase-fd itself and hands the value to the ghost
+// supervisor through TAZPOD_PASSPHRASE, which the supervisor clears at once.
+
+const PassphraseEnvVar = "TAZPOD_PASSPHRASE"
+
+// envPassphrase holds TAZPOD_PASSPHRASE once takeEnvPassphrase has removed
+// it from the environment.
+var envPassphrase string
+
+// takeEnvPassphrase runs first thing in the ghost supervisor, before any
+// branch that might not need a passphrase, so the value never reaches the
+// shell or its children through os.Environ().
+func takeEnvPassphrase() {
+	envPassphrase = os.Getenv(PassphraseEnvVar)
+	os.Unsetenv(PassphraseEnvVar)
+}
+
+// passphraseArgs runs in the outer process and returns the flags that must be
+// forwarded to internal-ghost.
+func passphraseArgs() []string {
+	if path := flagValue("--passphrase-file"); path != "" {
+		abs, _ := filepath.Abs(path)
+		return []string{"--passphrase-file", abs}
+	}
+	if fd := flagValue("--passphrase-fd"); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil { fmt.Printf("❌ Invalid --passphrase-fd: %s\n", fd); os.Exit(1) }
+		f := os.NewFile(uintptr(n), "passphrase-fd")
+		p, err := readLine(f)
+		f.Close()
+		if err != nil { fmt.Printf("❌ Cannot read passphrase from fd %d: %v\n", n, err); os.Exit(1) }
+		os.Setenv(PassphraseEnvVar, p)
+		return nil
+	}
+	if os.Getenv(PassphraseEnvVar) != "" {
+		fmt.Fprintf(os.Stderr, "⚠️  Using $%s: environment variables are inherited by child processes and visible in /proc. Prefer --passphrase-file or --passphrase-fd.\n", PassphraseEnvVar)
+	}
+	return nil
+}
+
+// readPassphrase runs in the ghost supervisor. Non-interactive sources are
+// never asked for confirmation, even when they create a new vault.
+func readPassphrase(newVault bool) string {
+	if path := flagValue("--passphrase-file"); path != "" {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			fmt.Fprintf(os.Stderr, "⚠️  %s is readable by other users (mode %v). Run 'chmod 600' on it.\n", path, info.Mode().Perm())
+		}
+		f, err := os.Open(path)
+		if err != nil { fmt.Printf("❌ Cannot read passphrase file: %v\n", err); os.Exit(1) }
+		defer f.Close()
+		p, err := readLine(f)
+		if err != nil { fmt.Printf("❌ Cannot read passphrase file: %v\n", err); os.Exit(1) }
+		return p
+	}
+	if p := envPassphrase; p != "" {
+		envPassphrase = ""
+		return p
+	}
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		logDebug("Reading passphrase from stdin")
+		p, err := readLine(os.Stdin)
+		if err != nil { fmt.Printf("❌ No passphrase on stdin: %v\n", err); os.Exit(1) }
+		return p
+	}
+
+	if newVault { return readNewPassphrase("Define Passphrase") }
+	fmt.Print("🔑 Enter Passphrase: "); p, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
+	return string(p)
+}
+
+// readLine reads a single line without buffering past it, so piped stdin is
+// left intact for whatever runs next.
+func readLine(f *os.File) (string, error) {
+	var b strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := f.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' { break }
+			b.WriteByte(buf[0])
+		}
+		if err != nil {
+			if b.Len() > 0 { break }
+			return "", err
+		}
+	}
+	p := strings.TrimSuffix(b.String(), "\r")
+	if p == "" { return "", fmt.Errorf("empty passphrase") }
+	return p, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerUID returns the uid of the process on the other end of a unix socket.
+func peerUID(conn net.Conn) (int, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok { return -1, fmt.Errorf("not a unix socket") }
+	raw, err := uc.SyscallConn()
+	if err != nil { return -1, err }
+	var cred *syscall.Ucred
+	var credErr error
+	raw.Control(func(fd uintptr) { cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED) })
+	if credErr != nil { return -1, credErr }
+	return int(cred.Uid), nil
+}
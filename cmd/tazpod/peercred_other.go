@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerUID is only available inside the Linux container.
+func peerUID(net.Conn) (int, error) { return -1, fmt.Errorf("peer credentials not supported") }
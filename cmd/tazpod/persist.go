@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// --- IDENTITY BRIDGES ---
+//
+// Tool homes such as ~/.aws or ~/.kube live inside the vault and are bind
+// mounted over their usual path for the lifetime of the ghost session. The
+// built-in Infisical and Gemini bridges are always present, ~/.vault-token
+// too when a secrets.yml mapping uses the vault provider. The 'persist:' list
+// in config.yaml adds more, or overrides a built-in one by path.
+
+type PersistEntry struct {
+	Path  string `yaml:"path"`  // where the tool expects it, "~/" is /home/tazpod/
+	Vault string `yaml:"vault"` // location inside the vault, default .persist/<path under home>
+	Owner string `yaml:"owner"` // "user" or "user:group", default tazpod
+	Mode  string `yaml:"mode"`  // octal, default 0700 for dirs and 0600 for files
+	Type  string `yaml:"type"`  // dir (default) or file
+}
+
+const (
+	HomeDir    = "/home/tazpod"
+	PersistDir = ".persist"
+	// PreTazpodSuffix marks plaintext that was found next to an existing vault copy
+	PreTazpodSuffix = ".pre-tazpod"
+)
+
+// bridgeSpec is a PersistEntry resolved to absolute paths and numbers.
+type bridgeSpec struct {
+	local, vault string
+	uid, gid     int
+	mode         os.FileMode
+	file         bool
+}
+
+func defaultPersist() []PersistEntry {
+	entries := []PersistEntry{
+		{Path: InfisicalLocalHome, Vault: strings.TrimPrefix(InfisicalVaultDir, MountPath+"/")},
+		{Path: InfisicalKeyringLocal, Vault: strings.TrimPrefix(InfisicalKeyringVault, MountPath+"/")},
+		{Path: GeminiLocalHome, Vault: strings.TrimPrefix(GeminiVaultDir, MountPath+"/")},
+	}
+	if providerUsed("vault") { entries = append(entries, PersistEntry{Path: VaultTokenFile, Vault: ".vault-token", Type: "file"}) }
+	return entries
+}
+
+func expandHome(path string) string {
+	if path == "~" { return HomeDir }
+	if strings.HasPrefix(path, "~/") { return filepath.Join(HomeDir, path[2:]) }
+	return filepath.Clean(path)
+}
+
+// bridges returns the built-in bridges followed by the configured ones.
+// Invalid entries are reported and skipped.
+func bridges() []bridgeSpec {
+	entries := defaultPersist()
+	for _, e := range cfg.Persist {
+		replaced := false
+		for i := range entries {
+			if expandHome(entries[i].Path) == expandHome(e.Path) { entries[i], replaced = e, true }
+		}
+		if !replaced { entries = append(entries, e) }
+	}
+	var out []bridgeSpec
+	for _, e := range entries {
+		spec, err := resolvePersist(e)
+		if err != nil { fmt.Printf("⚠️  Ignoring persist entry %q: %v\n", e.Path, err); continue }
+		out = append(out, spec)
+	}
+	return out
+}
+
+func resolvePersist(e PersistEntry) (bridgeSpec, error) {
+	s := bridgeSpec{local: expandHome(e.Path), uid: TazPodUID, gid: TazPodGID, file: e.Type == "file"}
+	if e.Type != "" && e.Type != "dir" && e.Type != "file" { return s, fmt.Errorf("type must be dir or file") }
+	if !filepath.IsAbs(s.local) || s.local == HomeDir || s.local == MountPath || strings.HasPrefix(s.local, MountPath+"/") {
+		return s, fmt.Errorf("path must be absolute and outside the vault")
+	}
+
+	sub := e.Vault
+	if sub == "" {
+		rel, err := filepath.Rel(HomeDir, s.local)
+		if err != nil || strings.HasPrefix(rel, "..") { rel = strings.TrimPrefix(s.local, "/") }
+		sub = filepath.Join(PersistDir, rel)
+	}
+	s.vault = filepath.Join(MountPath, sub)
+	if rel, err := filepath.Rel(MountPath, s.vault); err != nil || rel == "." || strings.HasPrefix(rel, "..") { return s, fmt.Errorf("vault must be a subdirectory of the vault") }
+
+	s.mode = 0700
+	if s.file { s.mode = 0600 }
+	if e.Mode != "" {
+		m, err := strconv.ParseUint(e.Mode, 8, 32)
+		if err != nil || m > 0777 { return s, fmt.Errorf("invalid mode %q", e.Mode) }
+		s.mode = os.FileMode(m)
+	}
+	if e.Owner != "" {
+		uid, gid, err := lookupOwner(e.Owner)
+		if err != nil { return s, err }
+		s.uid, s.gid = uid, gid
+	}
+	return s, nil
+}
+
+// lookupOwner accepts names or numeric ids, "user" alone uses the user's primary group.
+func lookupOwner(owner string) (int, int, error) {
+	name, group, hasGroup := strings.Cut(owner, ":")
+	u, err := user.Lookup(name)
+	if err != nil { u, err = user.LookupId(name) }
+	if err != nil { return 0, 0, fmt.Errorf("unknown owner %q", name) }
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil { g, err = user.LookupGroupId(group) }
+		if err != nil { return 0, 0, fmt.Errorf("unknown group %q", group) }
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+// setupBindAuth resolves the bridges once and records them on the session,
+// so the teardown undoes exactly what was bound even if persist changed.
+func setupBindAuth() {
+	specs := bridges()
+	for _, b := range specs { bridge(b) }
+	currentSession.setBridges(specs)
+}
+
+// unbridgeAll undoes setupBindAuth, innermost paths first. Without a
+// session the bridges go away with the ghost namespace.
+func unbridgeAll(s *session) {
+	specs := s.takeBridges()
+	for i := len(specs) - 1; i >= 0; i-- {
+		if err := unmount(specs[i].local); err != nil { logDebug("%v", err) }
+	}
+}
+
+// bridge moves any plaintext at the local path into the vault, then bind
+// mounts the vault copy over it. An ephemeral vault is thrown away at exit,
+// so there the plaintext is only hidden for the session, never moved.
+func bridge(b bridgeSpec) {
+	if isMounted(b.local) { return }
+	if info, err := os.Lstat(b.local); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		fmt.Printf("⚠️  Not bridging %s: it is a symlink.\n", b.local); return
+	}
+	mkdirOwned(filepath.Dir(b.vault), TazPodUID, TazPodGID)
+	mkdirOwned(filepath.Dir(b.local), b.uid, b.gid)
+
+	if _, ok := backend().(ramBackend); ok {
+		logDebug("Ephemeral vault, leaving %s in place", b.local)
+	} else if n, err := migratePlaintext(b); err != nil {
+		fmt.Printf("⚠️  Could not move %s into the vault, not bridging it: %v\n", b.local, err); return
+	} else if n > 0 {
+		fmt.Printf("🔐 Moved %d plaintext item(s) from %s into the vault.\n", n, b.local)
+	}
+
+	if b.file {
+		if !fileExist(b.vault) { os.WriteFile(b.vault, nil, b.mode) }
+		if !fileExist(b.local) { os.WriteFile(b.local, nil, 0600); os.Chown(b.local, b.uid, b.gid) }
+	} else {
+		os.MkdirAll(b.vault, b.mode)
+		os.MkdirAll(b.local, 0700); os.Chown(b.local, b.uid, b.gid)
+	}
+	if err := chownR(b.vault, b.uid, b.gid); err != nil { logDebug("%v", err) }
+	os.Chmod(b.vault, b.mode)
+	if err := bindMount(b.vault, b.local); err != nil { fmt.Printf("⚠️  Identity bridge unavailable: %v\n", err) }
+}
+
+// migratePlaintext moves what a tool left outside the vault into it. When
+// the vault already has an item of the same name, the vault copy wins and the
+// local one is kept beside it under preservedName: nothing is lost, not even
+// by a later migration, and nothing stays in the clear. It returns how many
+// items were moved.
+func migratePlaintext(b bridgeSpec) (int, error) {
+	info, err := os.Lstat(b.local)
+	if os.IsNotExist(err) { return 0, nil }
+	if err != nil { return 0, err }
+
+	if b.file {
+		if !info.Mode().IsRegular() { return 0, fmt.Errorf("expected a file") }
+		if info.Size() == 0 { return 0, nil }
+		dst := b.vault
+		if fileExist(dst) { dst = preservedName(dst) }
+		if err := moveTree(b.local, dst); err != nil { return 0, err }
+		return 1, nil
+	}
+
+	if !info.IsDir() { return 0, fmt.Errorf("expected a directory") }
+	entries, err := os.ReadDir(b.local)
+	if err != nil { return 0, err }
+	if len(entries) > 0 { os.MkdirAll(b.vault, b.mode) }
+	for i, e := range entries {
+		dst := filepath.Join(b.vault, e.Name())
+		if _, err := os.Lstat(dst); err == nil { dst = preservedName(dst) }
+		if err := moveTree(filepath.Join(b.local, e.Name()), dst); err != nil { return i, err }
+	}
+	return len(entries), nil
+}
+
+// preservedName is the first free name for a local copy of path:
+// path.pre-tazpod, then path.pre-tazpod.1 and so on.
+func preservedName(path string) string {
+	name := path + PreTazpodSuffix
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) { return name }
+		name = fmt.Sprintf("%s%s.%d", path, PreTazpodSuffix, i)
+	}
+}
+
+// moveTree renames src to dst, copying when they are on different
+// filesystems (the vault always is).
+func moveTree(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) { return err }
+	if err := copyTree(src, dst); err != nil { os.RemoveAll(dst); return err }
+	return os.RemoveAll(src)
+}
+
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil { return err }
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil // sockets and fifos are runtime state, not worth keeping
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil { return err }
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil { return err }
+	if _, err := io.Copy(out, in); err != nil { out.Close(); return err }
+	return out.Close()
+}
+
+// mkdirOwned creates missing parents of a bridge owned by the tool's user,
+// so root does not end up owning e.g. ~/.config.
+func mkdirOwned(dir string, uid, gid int) {
+	if fileExist(dir) { return }
+	mkdirOwned(filepath.Dir(dir), uid, gid)
+	if os.Mkdir(dir, 0755) == nil { os.Chown(dir, uid, gid) }
+}
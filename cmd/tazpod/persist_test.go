@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// standInBridge returns a bridge between a fake home directory and a fake
+// vault, both in a temporary directory, with activeBackend set to b.
+func standInBridge(t *testing.T, b vaultBackend, file bool) bridgeSpec {
+	t.Helper()
+	old := activeBackend
+	t.Cleanup(func() { activeBackend = old })
+	activeBackend = b
+	dir := t.TempDir()
+	spec := bridgeSpec{local: filepath.Join(dir, "home", ".aws"), vault: filepath.Join(dir, "vault", PersistDir, ".aws"), uid: os.Getuid(), gid: os.Getgid(), mode: 0700, file: file}
+	if file { spec.local, spec.vault, spec.mode = filepath.Join(dir, "home", ".vault-token"), filepath.Join(dir, "vault", ".vault-token"), 0600 }
+	os.MkdirAll(filepath.Dir(spec.local), 0700)
+	os.MkdirAll(filepath.Dir(spec.vault), 0700)
+	return spec
+}
+
+func TestEphemeralBridgeKeepsHomeCopies(t *testing.T) {
+	dir := standInBridge(t, ramBackend{}, false)
+	os.MkdirAll(dir.local, 0700)
+	os.WriteFile(filepath.Join(dir.local, "credentials"), []byte("aws"), 0600)
+	file := standInBridge(t, ramBackend{}, true)
+	os.WriteFile(file.local, []byte("token"), 0600)
+
+	for _, b := range []bridgeSpec{dir, file} {
+		bridge(b)
+		unmount(b.local) // the session ends
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir.local, "credentials")); err != nil || string(data) != "aws" { t.Errorf("home directory copy = %q, %v", data, err) }
+	if data, err := os.ReadFile(file.local); err != nil || string(data) != "token" { t.Errorf("home file copy = %q, %v", data, err) }
+	if _, err := os.Stat(filepath.Join(dir.vault, "credentials")); err == nil { t.Error("plaintext was moved into the ephemeral vault") }
+	if data, _ := os.ReadFile(file.vault); len(data) != 0 { t.Errorf("ephemeral vault file = %q, want empty", data) }
+}
+
+func TestMigrationKeepsEarlierPreservedCopies(t *testing.T) {
+	b := standInBridge(t, &luksBackend{}, false)
+	os.MkdirAll(b.vault, 0700)
+	os.WriteFile(filepath.Join(b.vault, "config"), []byte("vault"), 0600)
+	for _, local := range []string{"first", "second", "third"} {
+		os.MkdirAll(b.local, 0700)
+		os.WriteFile(filepath.Join(b.local, "config"), []byte(local), 0600)
+		if n, err := migratePlaintext(b); n != 1 || err != nil { t.Fatalf("migratePlaintext = %d, %v", n, err) }
+	}
+	for name, want := range map[string]string{"config": "vault", "config.pre-tazpod": "first", "config.pre-tazpod.1": "second", "config.pre-tazpod.2": "third"} {
+		if data, err := os.ReadFile(filepath.Join(b.vault, name)); err != nil || string(data) != want { t.Errorf("%s = %q, %v; want %q", name, data, err, want) }
+	}
+
+	f := standInBridge(t, &luksBackend{}, true)
+	os.WriteFile(f.vault, []byte("vault"), 0600)
+	os.WriteFile(f.vault+PreTazpodSuffix, []byte("first"), 0600)
+	os.WriteFile(f.local, []byte("second"), 0600)
+	if n, err := migratePlaintext(f); n != 1 || err != nil { t.Fatalf("migratePlaintext = %d, %v", n, err) }
+	if data, _ := os.ReadFile(f.vault + PreTazpodSuffix); string(data) != "first" { t.Errorf("earlier preserved file = %q, want first", data) }
+	if data, _ := os.ReadFile(f.vault + PreTazpodSuffix + ".1"); string(data) != "second" { t.Errorf("new preserved file = %q, want second", data) }
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- SECRET PROVIDERS ---
+//
+// 'pull' fills the vault from one or more secret backends. Each secrets.yml
+// mapping names its backend with 'provider:', defaulting to config.provider
+// and then to Infisical. Providers register a factory under their name and
+// read their own settings from the 'config:' section of secrets.yml.
+
+// SecretProvider is a source of secrets for 'pull'.
+type SecretProvider interface {
+	// Authenticate makes sure there is a usable session, prompting for a
+	// login only when interactive is true.
+	Authenticate(ctx context.Context, interactive bool) error
+	// List returns the names of the secrets the provider can serve.
+	List(ctx context.Context) ([]string, error)
+	// Get returns the value for one mapping. It is called concurrently.
+	Get(ctx context.Context, m SecretMapping) ([]byte, error)
+	// Export returns every secret as dotenv 'export KEY=value' lines for
+	// EnvFile, or nil when the provider has no such notion.
+	Export(ctx context.Context) ([]byte, error)
+}
+
+// secretPusher is implemented by providers that can write a mapping back,
+// see push.go.
+type secretPusher interface {
+	// Put creates or replaces the upstream value of one mapping.
+	Put(ctx context.Context, m SecretMapping, value []byte) error
+}
+
+// missingError is returned by Get when the provider has no such secret,
+// which 'push' takes as a secret to create.
+type missingError struct{ msg string }
+
+func (e *missingError) Error() string { return e.msg }
+
+func missing(format string, a ...interface{}) error { return &missingError{fmt.Sprintf(format, a...)} }
+
+const DefaultProvider = "infisical"
+
+// providerTimeout is the default deadline of every request to a secrets
+// backend (config.timeout); a pull should never hang on the network.
+const providerTimeout = 30 * time.Second
+
+var providerFactories = map[string]func() SecretProvider{}
+
+// registerProvider is called from the init function of each provider file.
+func registerProvider(name string, factory func() SecretProvider) { providerFactories[name] = factory }
+
+// providerInstances keeps one instance per name, so a pull authenticates once.
+var providerInstances = map[string]SecretProvider{}
+
+func providerName(m SecretMapping) string {
+	if m.Provider != "" { return m.Provider }
+	if secCfg.Config.Provider != "" { return secCfg.Config.Provider }
+	return DefaultProvider
+}
+
+func providerFor(name string) (SecretProvider, error) {
+	if p, ok := providerInstances[name]; ok { return p, nil }
+	factory, ok := providerFactories[name]
+	if !ok { return nil, fmt.Errorf("unknown secrets provider %q (available: %s)", name, strings.Join(providerNames(), ", ")) }
+	p := factory()
+	providerInstances[name] = p
+	return p, nil
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories { names = append(names, name) }
+	sort.Strings(names)
+	return names
+}
+
+// usedProviders returns the names the current secrets.yml needs, default first.
+func usedProviders() []string {
+	def := providerName(SecretMapping{})
+	names := []string{def}
+	seen := map[string]bool{def: true}
+	for _, m := range secCfg.Secrets {
+		if n := providerName(m); !seen[n] { seen[n] = true; names = append(names, n) }
+	}
+	return names
+}
+
+func providerUsed(name string) bool {
+	for _, n := range usedProviders() {
+		if n == name { return true }
+	}
+	return false
+}
+
+func internalEnsureAuth() {
+	for _, name := range usedProviders() {
+		p, err := providerFor(name)
+		if err != nil { fmt.Printf("❌ %v\n", err); continue }
+		if err := p.Authenticate(context.Background(), true); err != nil { fmt.Printf("⚠️  %s: %v\n", name, err) }
+	}
+}
+
+// --- SECRETS COMMAND ---
+
+func secretsCmd() {
+	args := positional()
+	if len(args) == 0 || (args[0] != "list" && args[0] != "diff") { fmt.Println("Usage: tazpod secrets list [provider] | diff"); os.Exit(1) }
+	if os.Getenv(GhostEnvVar) != "true" { fmt.Println("❌ Vault is closed. Run 'tazpod unlock' first."); os.Exit(1) }
+	if args[0] == "diff" { os.Exit(diffSecrets(false)) }
+	names := usedProviders()
+	if len(args) > 1 { names = args[1:] }
+	for _, name := range names {
+		p, err := providerFor(name)
+		ctx := context.Background()
+		if err == nil { err = p.Authenticate(ctx, false) }
+		var secrets []string
+		if err == nil { secrets, err = p.List(ctx) }
+		if err != nil { fmt.Printf("❌ %s: %v\n", name, err); continue }
+		fmt.Printf("🔐 %s (%d):\n", name, len(secrets))
+		for _, s := range secrets { fmt.Printf("   %s\n", s) }
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// --- INFISICAL PROVIDER ---
+//
+// Fetches an environment's secrets in one API call (infisical_api.go). The
+// token comes from, in order: $INFISICAL_TOKEN, a universal-auth machine
+// identity ($INFISICAL_UNIVERSAL_AUTH_CLIENT_ID/_SECRET or the
+// infisical_client_id / infisical_client_secret_file settings), or the user
+// session the Infisical CLI keeps in the vault through the ~/.infisical bridge.
+
+// InfisicalProjectFile is where 'infisical init' records the project.
+const InfisicalProjectFile = "/workspace/.infisical.json"
+
+func init() { registerProvider("infisical", func() SecretProvider { return &infisicalProvider{} }) }
+
+type infisicalProvider struct {
+	mu     sync.Mutex // guards client and user
+	client *infisicalClient
+	user   bool                    // the token is the CLI user session
+	values memo[map[string]string] // environment -> secrets
+}
+
+func infisicalProjectID() string {
+	if pID := secCfg.Config.ProjectID; pID != "" { return pID }
+	var project struct{ WorkspaceID string `json:"workspaceId"` }
+	if data, err := os.ReadFile(InfisicalProjectFile); err == nil { json.Unmarshal(data, &project) }
+	return project.WorkspaceID
+}
+
+// login gets a token without any prompt.
+func (p *infisicalProvider) login(ctx context.Context) error {
+	p.client, p.user = newInfisicalClient(), false
+	if token := os.Getenv("INFISICAL_TOKEN"); token != "" { p.client.token = token; return nil }
+
+	clientID, clientSecret := os.Getenv("INFISICAL_UNIVERSAL_AUTH_CLIENT_ID"), os.Getenv("INFISICAL_UNIVERSAL_AUTH_CLIENT_SECRET")
+	if clientID == "" { clientID = secCfg.Config.ClientID }
+	if clientSecret == "" && secCfg.Config.ClientSecretFile != "" {
+		data, err := os.ReadFile(filepath.Join(MountPath, secCfg.Config.ClientSecretFile))
+		if err != nil { return fmt.Errorf("universal auth client secret: %w", err) }
+		clientSecret = strings.TrimSpace(string(data))
+	}
+	if clientID != "" && clientSecret != "" { return p.client.universalAuthLogin(ctx, clientID, clientSecret) }
+	if clientID != "" || clientSecret != "" { return fmt.Errorf("universal auth needs both a client id and a client secret") }
+
+	p.user = true
+	if _, err := os.Stat(filepath.Join(InfisicalLocalHome, "infisical-config.json")); err != nil { return errNotLoggedIn }
+	out, err := runInfisical("user", "get", "token", "--plain")
+	if err != nil { return errNotLoggedIn }
+	lines := strings.Fields(strings.TrimSpace(string(out)))
+	if len(lines) == 0 { return errNotLoggedIn }
+	p.client.token = lines[len(lines)-1]
+	return nil
+}
+
+var errNotLoggedIn = errors.New("not logged in, run 'tazpod login'")
+
+// session returns a logged-in client, shared by every fetch of the pull.
+func (p *infisicalProvider) session(ctx context.Context) (*infisicalClient, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		if err := p.login(ctx); err != nil { p.client = nil; return nil, false, err }
+	}
+	return p.client, p.user, nil
+}
+
+func (p *infisicalProvider) fetch(ctx context.Context, env string) (map[string]string, error) {
+	client, user, err := p.session(ctx)
+	if err != nil { return nil, err }
+	pID := infisicalProjectID()
+	if pID == "" { return nil, fmt.Errorf("no project: set infisical_project_id in secrets.yml") }
+	values, err := client.secrets(ctx, pID, env, "/")
+	var apiErr *infisicalError
+	if user && errors.As(err, &apiErr) && apiErr.unauthorized() {
+		p.mu.Lock(); p.client = nil; p.mu.Unlock()
+		return nil, errNotLoggedIn
+	}
+	return values, err
+}
+
+// load fetches an environment once per pull, however many mappings want it.
+func (p *infisicalProvider) load(ctx context.Context, env string) (map[string]string, error) {
+	return p.values.do(env, func() (map[string]string, error) { return p.fetch(ctx, env) })
+}
+
+func (p *infisicalProvider) Authenticate(ctx context.Context, interactive bool) error {
+	env := currentEnv()
+	_, err := p.load(ctx, env)
+	if err == errNotLoggedIn && interactive {
+		internalLogin()
+		p.values.forget(env)
+		_, err = p.load(ctx, env)
+	}
+	return err
+}
+
+func (p *infisicalProvider) List(ctx context.Context) ([]string, error) {
+	values, err := p.load(ctx, currentEnv())
+	if err != nil { return nil, err }
+	names := make([]string, 0, len(values))
+	for name := range values { names = append(names, name) }
+	sort.Strings(names)
+	return names, nil
+}
+
+func (p *infisicalProvider) Get(ctx context.Context, m SecretMapping) ([]byte, error) {
+	env := mappingEnv(m)
+	values, err := p.load(ctx, env)
+	if err != nil { return nil, err }
+	val, ok := values[m.Name]
+	if !ok { return nil, missing("no secret %s in %s environment", m.Name, env) }
+	return []byte(val), nil
+}
+
+// Put updates the secret named after the mapping, creating it when missing.
+func (p *infisicalProvider) Put(ctx context.Context, m SecretMapping, value []byte) error {
+	client, _, err := p.session(ctx)
+	if err != nil { return err }
+	pID := infisicalProjectID()
+	if pID == "" { return fmt.Errorf("no project: set infisical_project_id in secrets.yml") }
+	env := mappingEnv(m)
+	err = client.setSecret(ctx, http.MethodPatch, pID, env, "/", m.Name, string(value))
+	var apiErr *infisicalError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		err = client.setSecret(ctx, http.MethodPost, pID, env, "/", m.Name, string(value))
+	}
+	p.values.forget(env)
+	return err
+}
+
+func (p *infisicalProvider) Export(ctx context.Context) ([]byte, error) {
+	values, err := p.load(ctx, currentEnv())
+	if err != nil { return nil, err }
+	names := make([]string, 0, len(values))
+	for name := range values { names = append(names, name) }
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		if !shellName(name) { continue }
+		fmt.Fprintf(&b, "export %s=%s\n", name, shellQuote(values[name]))
+	}
+	return []byte(b.String()), nil
+}
+
+// shellName reports whether s can be used as a shell variable name.
+func shellName(s string) bool {
+	for i, r := range s {
+		if r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (i > 0 && r >= '0' && r <= '9') { continue }
+		return false
+	}
+	return s != ""
+}
+
+// --- INFISICAL RUNNER ---
+
+func runInfisical(args ...string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if os.Geteuid() == 0 {
+		fullArgs := append([]string{"-u", "tazpod", "infisical"}, args...)
+		cmd = exec.Command("sudo", fullArgs...)
+	} else {
+		cmd = exec.Command("infisical", args...)
+	}
+	cmd.Env = append(os.Environ(), "HOME=/home/tazpod", "USER=tazpod", "INFISICAL_VAULT_BACKEND=file")
+	return cmd.CombinedOutput()
+}
+
+func runInfisicalInteractive(args ...string) error {
+	var cmd *exec.Cmd
+	if os.Geteuid() == 0 {
+		fullArgs := append([]string{"-u", "tazpod", "infisical"}, args...)
+		cmd = exec.Command("sudo", fullArgs...)
+	} else {
+		cmd = exec.Command("infisical", args...)
+	}
+	cmd.Env = append(os.Environ(), "HOME=/home/tazpod", "USER=tazpod", "INFISICAL_VAULT_BACKEND=file")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- SOPS PROVIDER ---
+//
+// Decrypts SOPS-encrypted YAML, JSON or dotenv files from the repository
+// with the age identity kept in the vault. 'path' is relative to /workspace
+// and 'key' (dot separated for nested values) picks one value; without it
+// the whole decrypted file is written. sops only ever writes to our stdout,
+// so plaintext goes from memory straight into the vault. The age identity is
+// the one a mapping fetched earlier in the same pull (handed over in
+// $SOPS_AGE_KEY), or else the file already in the vault.
+
+type SopsConfig struct {
+	AgeKeyFile string `yaml:"age_key_file"` // relative to the vault, default age.key
+}
+
+const WorkspaceDir = "/workspace"
+
+func init() { registerProvider("sops", func() SecretProvider { return &sopsProvider{} }) }
+
+type sopsProvider struct {
+	trees    memo[map[string]interface{}] // path -> decrypted tree
+	identity []byte                       // age identity fetched by this pull
+}
+
+// setSopsIdentity hands the sops provider an age identity that is not in the
+// vault yet. It is called before any sops mapping is fetched.
+func setSopsIdentity(key []byte) {
+	if p, err := providerFor("sops"); err == nil { p.(*sopsProvider).identity = key }
+}
+
+func sopsAgeKey() string {
+	if f := secCfg.Config.Sops.AgeKeyFile; f != "" { return filepath.Join(MountPath, f) }
+	return filepath.Join(MountPath, "age.key")
+}
+
+// sopsFile resolves a mapping path inside the workspace.
+func sopsFile(path string) (string, error) {
+	if path == "" { return "", fmt.Errorf("sops mapping needs a path") }
+	full := filepath.Join(WorkspaceDir, path)
+	if !strings.HasPrefix(full, WorkspaceDir+"/") { return "", fmt.Errorf("sops path %s is outside %s", path, WorkspaceDir) }
+	return full, nil
+}
+
+// Authenticate only checks that sops is installed; the identity is usually
+// fetched by a mapping of the same pull.
+func (p *sopsProvider) Authenticate(context.Context, bool) error {
+	if _, err := exec.LookPath("sops"); err != nil { return fmt.Errorf("sops is not installed") }
+	if !fileExist(sopsAgeKey()) { logDebug("No age identity at %s yet", sopsAgeKey()) }
+	return nil
+}
+
+// decrypt only gets a deadline: a failed decryption is not worth retrying.
+func (p *sopsProvider) decrypt(ctx context.Context, file string, args ...string) ([]byte, error) {
+	identity := "SOPS_AGE_KEY_FILE=" + sopsAgeKey()
+	if p.identity != nil {
+		identity = "SOPS_AGE_KEY=" + string(p.identity)
+	} else if !fileExist(sopsAgeKey()) {
+		return nil, fmt.Errorf("no age identity at %s", sopsAgeKey())
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sops", append(append([]string{"--decrypt"}, args...), file)...)
+	cmd.Dir = MountPath
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + HomeDir, identity}
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" { msg = err.Error() }
+		return nil, fmt.Errorf("sops %s: %s", strings.TrimPrefix(file, WorkspaceDir+"/"), msg)
+	}
+	return out.Bytes(), nil
+}
+
+// tree decrypts a file once per pull into a generic document.
+func (p *sopsProvider) tree(ctx context.Context, path string) (map[string]interface{}, error) {
+	return p.trees.do(path, func() (map[string]interface{}, error) {
+		file, err := sopsFile(path)
+		if err != nil { return nil, err }
+		out, err := p.decrypt(ctx, file, "--output-type", "json")
+		if err != nil { return nil, err }
+		var t map[string]interface{}
+		if err := json.Unmarshal(out, &t); err != nil { return nil, fmt.Errorf("sops %s: %w", path, err) }
+		return t, nil
+	})
+}
+
+func (p *sopsProvider) Get(ctx context.Context, m SecretMapping) ([]byte, error) {
+	if m.Key == "" {
+		file, err := sopsFile(mappingPath(m))
+		if err != nil { return nil, err }
+		return p.decrypt(ctx, file)
+	}
+	t, err := p.tree(ctx, mappingPath(m))
+	if err != nil { return nil, err }
+	var val interface{} = t
+	for _, part := range strings.Split(m.Key, ".") {
+		obj, ok := val.(map[string]interface{})
+		if ok { val, ok = obj[part] }
+		if !ok { return nil, fmt.Errorf("no key %s in %s", m.Key, mappingPath(m)) }
+	}
+	if s, ok := val.(string); ok { return []byte(s), nil }
+	return json.Marshal(val)
+}
+
+// List returns the top-level keys of every file the mappings use, as path:key.
+func (p *sopsProvider) List(ctx context.Context) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range secCfg.Secrets {
+		path := mappingPath(m)
+		if providerName(m) != "sops" || seen[path] { continue }
+		seen[path] = true
+		t, err := p.tree(ctx, path)
+		if err != nil { return nil, err }
+		for k := range t {
+			if k != "sops" { names = append(names, path+":"+k) }
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Export has nothing to add: sops values only reach the env through mappings.
+func (p *sopsProvider) Export(context.Context) ([]byte, error) { return nil, nil }
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// --- VAULT / OPENBAO PROVIDER ---
+//
+// Reads KV v2 secrets from HashiCorp Vault or OpenBao. A mapping names the
+// secret with 'path' (under config.vault.mount), an optional 'key' inside it
+// and an optional 'version'. The token lives in ~/.vault-token, which is an
+// identity bridge into the vault, so the vault CLI in the ghost shell shares
+// it. Every pull renews it, or logs in again with token, AppRole or userpass
+// auth when it has expired.
+
+type VaultConfig struct {
+	Addr         string `yaml:"addr"`           // default $VAULT_ADDR
+	Namespace    string `yaml:"namespace"`      // Vault Enterprise namespace, default $VAULT_NAMESPACE
+	Mount        string `yaml:"mount"`          // KV v2 mount, default "secret"
+	Auth         string `yaml:"auth"`           // token (default), approle or userpass
+	AuthPath     string `yaml:"auth_path"`      // auth mount, default the method name
+	RoleID       string `yaml:"role_id"`        // approle, default $VAULT_ROLE_ID
+	SecretIDFile string `yaml:"secret_id_file"` // approle, relative to the vault, default $VAULT_SECRET_ID
+	Username     string `yaml:"username"`       // userpass, the password is asked for
+}
+
+// VaultTokenFile is where the vault CLI looks for its token.
+const VaultTokenFile = HomeDir + "/.vault-token"
+
+func init() { registerProvider("vault", func() SecretProvider { return &vaultProvider{tokenFile: VaultTokenFile} }) }
+
+type vaultProvider struct {
+	mu        sync.Mutex // guards token and authErr
+	token     string
+	tokenFile string                       // VaultTokenFile
+	authErr   error                        // a failed non-interactive login is not retried for every mapping
+	cache     memo[map[string]interface{}] // path@version -> data
+}
+
+// vaultError is a non-2xx answer, with the messages from Vault's "errors" list.
+type vaultError struct {
+	Method string
+	Path   string
+	Status int
+	Errors []string
+}
+
+func (e *vaultError) Error() string {
+	msg := fmt.Sprintf("vault %s %s: %d %s", e.Method, e.Path, e.Status, http.StatusText(e.Status))
+	if len(e.Errors) > 0 { msg += ": " + strings.Join(e.Errors, "; ") }
+	return msg
+}
+
+func (e *vaultError) temporary() bool { return e.Status == http.StatusTooManyRequests || e.Status >= 500 }
+
+func vaultAddr() string {
+	addr := secCfg.Config.Vault.Addr
+	if addr == "" { addr = os.Getenv("VAULT_ADDR") }
+	return strings.TrimRight(addr, "/")
+}
+
+// vaultHost is the host the egress proxy must let through, empty when unused.
+func vaultHost() string {
+	if !providerUsed("vault") { return "" }
+	u, err := url.Parse(vaultAddr())
+	if err != nil { return "" }
+	return u.Hostname()
+}
+
+func vaultMount() string {
+	if m := strings.Trim(secCfg.Config.Vault.Mount, "/"); m != "" { return m }
+	return "secret"
+}
+
+func (p *vaultProvider) do(ctx context.Context, method, path string, body, out interface{}) error {
+	return withRetry(ctx, "vault "+method+" "+path, func(ctx context.Context) error { return p.doOnce(ctx, method, path, body, out) })
+}
+
+func (p *vaultProvider) doOnce(ctx context.Context, method, path string, body, out interface{}) error {
+	addr := vaultAddr()
+	if addr == "" { return fmt.Errorf("no Vault address: set config.vault.addr in secrets.yml or $VAULT_ADDR") }
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil { return err }
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, addr+"/v1/"+path, payload)
+	if err != nil { return err }
+	if p.token != "" { req.Header.Set("X-Vault-Token", p.token) }
+	ns := secCfg.Config.Vault.Namespace
+	if ns == "" { ns = os.Getenv("VAULT_NAMESPACE") }
+	if ns != "" { req.Header.Set("X-Vault-Namespace", ns) }
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil { return fmt.Errorf("vault %s %s: %w", method, path, err) }
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil { return fmt.Errorf("vault %s %s: %w", method, path, err) }
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct{ Errors []string `json:"errors"` }
+		json.Unmarshal(data, &apiErr)
+		return &vaultError{Method: method, Path: path, Status: resp.StatusCode, Errors: apiErr.Errors}
+	}
+	if out == nil || len(data) == 0 { return nil }
+	if err := json.Unmarshal(data, out); err != nil { return fmt.Errorf("vault %s %s: bad response: %w", method, path, err) }
+	return nil
+}
+
+// Authenticate reuses and renews a stored token, logging in again when it
+// is missing or rejected.
+func (p *vaultProvider) Authenticate(ctx context.Context, interactive bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.authenticate(ctx, interactive)
+}
+
+// ensureToken authenticates once, without prompting, for concurrent fetches.
+func (p *vaultProvider) ensureToken(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == "" && p.authErr == nil { p.authErr = p.authenticate(ctx, false) }
+	if p.token != "" { return nil }
+	return p.authErr
+}
+
+func (p *vaultProvider) authenticate(ctx context.Context, interactive bool) error {
+	if p.token != "" { return nil }
+	fromEnv := os.Getenv("VAULT_TOKEN")
+	p.token = fromEnv
+	if p.token == "" {
+		if data, err := os.ReadFile(p.tokenFile); err == nil { p.token = strings.TrimSpace(string(data)) }
+	}
+	if p.token != "" {
+		err := p.renew(ctx)
+		if err == nil { return nil }
+		if e, ok := err.(*vaultError); !ok || e.Status != http.StatusForbidden { p.token = ""; return err }
+		logDebug("Stored Vault token rejected, logging in again")
+		p.token = ""
+		if fromEnv != "" { return fmt.Errorf("$VAULT_TOKEN was rejected") }
+	}
+	if err := p.login(ctx, interactive); err != nil { p.token = ""; return err }
+	os.WriteFile(p.tokenFile, []byte(p.token), 0600)
+	os.Chown(p.tokenFile, TazPodUID, TazPodGID)
+	return nil
+}
+
+// renew checks the token and extends it when it is renewable.
+func (p *vaultProvider) renew(ctx context.Context) error {
+	var self struct{ Data struct{ Renewable bool `json:"renewable"` } `json:"data"` }
+	if err := p.do(ctx, http.MethodGet, "auth/token/lookup-self", nil, &self); err != nil { return err }
+	if !self.Data.Renewable { return nil }
+	if err := p.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]string{}, nil); err != nil {
+		fmt.Printf("⚠️  Vault token not renewed: %v\n", err)
+	}
+	return nil
+}
+
+func (p *vaultProvider) login(ctx context.Context, interactive bool) error {
+	c := secCfg.Config.Vault
+	method := c.Auth
+	if method == "" { method = "token" }
+	mount := strings.Trim(c.AuthPath, "/")
+	if mount == "" { mount = method }
+
+	var resp struct{ Auth struct{ ClientToken string `json:"client_token"` } `json:"auth"` }
+	switch method {
+	case "token":
+		if !interactive || !term.IsTerminal(int(syscall.Stdin)) { return fmt.Errorf("no Vault token: set $VAULT_TOKEN or run 'tazpod pull' interactively") }
+		fmt.Print("🔑 Vault token: "); t, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
+		p.token = strings.TrimSpace(string(t))
+		if p.token == "" { return fmt.Errorf("no Vault token given") }
+		return p.renew(ctx)
+	case "approle":
+		roleID, secretID := c.RoleID, os.Getenv("VAULT_SECRET_ID")
+		if roleID == "" { roleID = os.Getenv("VAULT_ROLE_ID") }
+		if c.SecretIDFile != "" {
+			data, err := os.ReadFile(filepath.Join(MountPath, c.SecretIDFile))
+			if err != nil { return fmt.Errorf("approle secret_id: %w", err) }
+			secretID = strings.TrimSpace(string(data))
+		}
+		if roleID == "" || secretID == "" { return fmt.Errorf("approle needs a role_id and a secret_id") }
+		if err := p.do(ctx, http.MethodPost, "auth/"+mount+"/login", map[string]string{"role_id": roleID, "secret_id": secretID}, &resp); err != nil { return err }
+	case "userpass":
+		if c.Username == "" { return fmt.Errorf("userpass needs config.vault.username") }
+		if !interactive || !term.IsTerminal(int(syscall.Stdin)) { return fmt.Errorf("Vault login for %s needs a terminal", c.Username) }
+		fmt.Printf("🔑 Vault password for %s: ", c.Username); pw, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
+		if err := p.do(ctx, http.MethodPost, "auth/"+mount+"/login/"+url.PathEscape(c.Username), map[string]string{"password": string(pw)}, &resp); err != nil { return err }
+	default:
+		return fmt.Errorf("unknown config.vault.auth %q (use token, approle or userpass)", method)
+	}
+	if resp.Auth.ClientToken == "" { return fmt.Errorf("vault login returned no token") }
+	p.token = resp.Auth.ClientToken
+	return nil
+}
+
+// read returns the data of one KV v2 secret, version 0 meaning the latest.
+func (p *vaultProvider) read(ctx context.Context, path string, version int) (map[string]interface{}, error) {
+	path = strings.Trim(path, "/")
+	return p.cache.do(path+"@"+strconv.Itoa(version), func() (map[string]interface{}, error) {
+		if err := p.ensureToken(ctx); err != nil { return nil, err }
+		api := vaultMount() + "/data/" + path
+		if version > 0 { api += "?version=" + strconv.Itoa(version) }
+		var resp struct{ Data struct{ Data map[string]interface{} `json:"data"` } `json:"data"` }
+		if err := p.do(ctx, http.MethodGet, api, nil, &resp); err != nil { return nil, err }
+		if resp.Data.Data == nil { return nil, fmt.Errorf("vault %s: secret deleted or destroyed", path) }
+		return resp.Data.Data, nil
+	})
+}
+
+func (p *vaultProvider) Get(ctx context.Context, m SecretMapping) ([]byte, error) {
+	if m.Path == "" { return nil, fmt.Errorf("vault mapping %q needs a path", m.Name) }
+	data, err := p.read(ctx, mappingPath(m), m.Version)
+	if e, ok := err.(*vaultError); ok && e.Status == http.StatusNotFound { return nil, missing("no secret %s", mappingPath(m)) }
+	if err != nil { return nil, err }
+	if m.Key == "" { return json.MarshalIndent(data, "", "  ") }
+	val, ok := data[m.Key]
+	if !ok { return nil, missing("no key %s in %s", m.Key, mappingPath(m)) }
+	if s, ok := val.(string); ok { return []byte(s), nil }
+	return json.Marshal(val)
+}
+
+// Put writes a new version of the secret with check-and-set against the
+// version it read, so a concurrent write upstream is never overwritten. With
+// a key only that key changes; without one the file must be a JSON object.
+func (p *vaultProvider) Put(ctx context.Context, m SecretMapping, value []byte) error {
+	if m.Path == "" { return fmt.Errorf("vault mapping %q needs a path", m.Name) }
+	if m.Version > 0 { return fmt.Errorf("mapping %q is pinned to version %d", m.Name, m.Version) }
+	if err := p.ensureToken(ctx); err != nil { return err }
+	path := strings.Trim(mappingPath(m), "/")
+	var cur struct {
+		Data struct {
+			Data     map[string]interface{} `json:"data"`
+			Metadata struct{ Version int `json:"version"` } `json:"metadata"`
+		} `json:"data"`
+	}
+	err := p.do(ctx, http.MethodGet, vaultMount()+"/data/"+path, nil, &cur)
+	if e, ok := err.(*vaultError); err != nil && !(ok && e.Status == http.StatusNotFound) { return err }
+
+	data := map[string]interface{}{}
+	if m.Key == "" {
+		if err := json.Unmarshal(value, &data); err != nil { return fmt.Errorf("%s is not a JSON object: %w", mappingFile(m), err) }
+	} else {
+		for k, v := range cur.Data.Data { data[k] = v }
+		var val interface{} = string(value)
+		// Keep non-string values (numbers, objects) in their JSON form
+		if old, ok := cur.Data.Data[m.Key]; ok {
+			if _, isString := old.(string); !isString { json.Unmarshal(value, &val) }
+		}
+		data[m.Key] = val
+	}
+	body := map[string]interface{}{"data": data, "options": map[string]int{"cas": cur.Data.Metadata.Version}}
+	return p.do(ctx, http.MethodPost, vaultMount()+"/data/"+path, body, nil)
+}
+
+// List walks the KV mount and returns every secret path.
+func (p *vaultProvider) List(ctx context.Context) ([]string, error) {
+	if err := p.ensureToken(ctx); err != nil { return nil, err }
+	var names []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		var resp struct{ Data struct{ Keys []string `json:"keys"` } `json:"data"` }
+		if err := p.do(ctx, "LIST", vaultMount()+"/metadata/"+dir, nil, &resp); err != nil {
+			if e, ok := err.(*vaultError); ok && e.Status == http.StatusNotFound { return nil }
+			return err
+		}
+		for _, k := range resp.Data.Keys {
+			if strings.HasSuffix(k, "/") {
+				if err := walk(dir + k); err != nil { return err }
+			} else {
+				names = append(names, dir+k)
+			}
+		}
+		return nil
+	}
+	if err := walk(""); err != nil { return nil, err }
+	sort.Strings(names)
+	return names, nil
+}
+
+// Export has nothing to add: Vault secrets only reach the env through mappings.
+func (p *vaultProvider) Export(context.Context) ([]byte, error) { return nil, nil }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// standInVault points config.vault at a stand-in server and returns a
+// provider whose token file lives in a temporary directory.
+func standInVault(t *testing.T, handler http.HandlerFunc) *vaultProvider {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	old := secCfg
+	t.Cleanup(func() { secCfg = old })
+	secCfg.Config.Vault = VaultConfig{Addr: srv.URL}
+	secCfg.Config.Retries = -1
+	t.Setenv("VAULT_TOKEN", "")
+	return &vaultProvider{tokenFile: filepath.Join(t.TempDir(), ".vault-token")}
+}
+
+func TestVaultReadKeyAndVersion(t *testing.T) {
+	p := standInVault(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "tok" { w.WriteHeader(http.StatusForbidden); return }
+		if r.URL.Path != "/v1/secret/data/platform/cluster" { w.WriteHeader(http.StatusNotFound); w.Write([]byte(`{"errors":[]}`)); return }
+		data := `{"kubeconfig":"latest","port":6443}`
+		if r.URL.Query().Get("version") == "2" { data = `{"kubeconfig":"v2","port":6443}` }
+		w.Write([]byte(`{"data":{"data":` + data + `,"metadata":{"version":3}}}`))
+	})
+	p.token = "tok"
+	ctx := context.Background()
+	for _, tc := range []struct {
+		m    SecretMapping
+		want string
+	}{
+		{SecretMapping{Name: "k", Path: "platform/cluster", Key: "kubeconfig"}, "latest"},
+		{SecretMapping{Name: "k", Path: "/platform/cluster/", Key: "kubeconfig", Version: 2}, "v2"},
+		{SecretMapping{Name: "k", Path: "platform/cluster", Key: "port"}, "6443"},
+	} {
+		got, err := p.Get(ctx, tc.m)
+		if err != nil || string(got) != tc.want { t.Errorf("Get(%+v) = %q, %v; want %q", tc.m, got, err, tc.want) }
+	}
+
+	whole, err := p.Get(ctx, SecretMapping{Name: "k", Path: "platform/cluster"})
+	var doc map[string]interface{}
+	if err != nil || json.Unmarshal(whole, &doc) != nil || doc["kubeconfig"] != "latest" { t.Errorf("whole secret = %s, %v", whole, err) }
+
+	var miss *missingError
+	if _, err := p.Get(ctx, SecretMapping{Name: "k", Path: "platform/cluster", Key: "nope"}); !errors.As(err, &miss) { t.Errorf("missing key: %v", err) }
+	if _, err := p.Get(ctx, SecretMapping{Name: "k", Path: "other"}); !errors.As(err, &miss) { t.Errorf("missing secret: %v", err) }
+}
+
+func TestVaultAppRoleLogin(t *testing.T) {
+	p := standInVault(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/auth/ci-approle/login" { w.WriteHeader(http.StatusNotFound); return }
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "sid" { w.WriteHeader(http.StatusBadRequest); w.Write([]byte(`{"errors":["invalid role or secret ID"]}`)); return }
+		w.Write([]byte(`{"auth":{"client_token":"approle-token"}}`))
+	})
+	secCfg.Config.Vault.Auth, secCfg.Config.Vault.AuthPath, secCfg.Config.Vault.RoleID = "approle", "ci-approle", "role"
+
+	t.Setenv("VAULT_SECRET_ID", "wrong")
+	err := p.login(context.Background(), false)
+	if err == nil || !strings.Contains(err.Error(), "invalid role or secret ID") { t.Errorf("login with a wrong secret_id: %v", err) }
+
+	t.Setenv("VAULT_SECRET_ID", "sid")
+	if err := p.login(context.Background(), false); err != nil { t.Fatal(err) }
+	if p.token != "approle-token" { t.Errorf("token = %q", p.token) }
+}
+
+func TestVaultExpiredTokenLogsInAgain(t *testing.T) {
+	p := standInVault(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			if r.Header.Get("X-Vault-Token") != "fresh" { w.WriteHeader(http.StatusForbidden); w.Write([]byte(`{"errors":["permission denied"]}`)); return }
+			w.Write([]byte(`{"data":{"renewable":false}}`))
+		case "/v1/auth/approle/login":
+			w.Write([]byte(`{"auth":{"client_token":"fresh"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	secCfg.Config.Vault.Auth, secCfg.Config.Vault.RoleID = "approle", "role"
+	t.Setenv("VAULT_SECRET_ID", "sid")
+	os.WriteFile(p.tokenFile, []byte("expired\n"), 0600)
+
+	if err := p.Authenticate(context.Background(), false); err != nil { t.Fatal(err) }
+	if p.token != "fresh" { t.Errorf("token = %q, want fresh", p.token) }
+	if data, _ := os.ReadFile(p.tokenFile); string(data) != "fresh" { t.Errorf("token file = %q, want fresh", data) }
+
+	// A rejected $VAULT_TOKEN is reported, not replaced behind the user's back
+	q := &vaultProvider{tokenFile: p.tokenFile}
+	t.Setenv("VAULT_TOKEN", "expired")
+	if err := q.Authenticate(context.Background(), false); err == nil { t.Error("rejected $VAULT_TOKEN was accepted") }
+}
+
+func TestVaultPutCheckAndSet(t *testing.T) {
+	var written map[string]interface{}
+	p := standInVault(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/app":
+			w.Write([]byte(`{"data":{"data":{"user":"u","password":"old","port":5432},"metadata":{"version":7}}}`))
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound); w.Write([]byte(`{"errors":[]}`))
+		case r.Method == http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&written)
+			if r.URL.Path == "/v1/secret/data/raced" { w.WriteHeader(http.StatusBadRequest); w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`)); return }
+			w.Write([]byte(`{"data":{"version":8}}`))
+		}
+	})
+	p.token = "tok"
+	ctx := context.Background()
+
+	if err := p.Put(ctx, SecretMapping{Name: "pw", Path: "app", Key: "password"}, []byte("new")); err != nil { t.Fatal(err) }
+	data := written["data"].(map[string]interface{})
+	if data["password"] != "new" || data["user"] != "u" || data["port"] != float64(5432) { t.Errorf("data = %v, other keys must be kept", data) }
+	if cas := written["options"].(map[string]interface{})["cas"]; cas != float64(7) { t.Errorf("cas = %v, want 7", cas) }
+
+	if err := p.Put(ctx, SecretMapping{Name: "port", Path: "app", Key: "port"}, []byte("6543")); err != nil { t.Fatal(err) }
+	if port := written["data"].(map[string]interface{})["port"]; port != float64(6543) { t.Errorf("port = %#v, numbers stay numbers", port) }
+
+	if err := p.Put(ctx, SecretMapping{Name: "new", Path: "fresh"}, []byte(`{"a":"b"}`)); err != nil { t.Fatal(err) }
+	if cas := written["options"].(map[string]interface{})["cas"]; cas != float64(0) { t.Errorf("cas for a new secret = %v, want 0", cas) }
+
+	if err := p.Put(ctx, SecretMapping{Name: "raced", Path: "raced"}, []byte(`{"a":"b"}`)); err == nil || !strings.Contains(err.Error(), "check-and-set") { t.Errorf("CAS conflict: %v", err) }
+	if err := p.Put(ctx, SecretMapping{Name: "pinned", Path: "app", Version: 3}, []byte(`{}`)); err == nil { t.Error("Put to a pinned version succeeded") }
+}
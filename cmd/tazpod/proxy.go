@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- EGRESS ALLOWLIST ---
+//
+// With ghost.network.policy "isolated" the ghost shell runs in its own network
+// namespace that has nothing but loopback. A helper inside that namespace
+// listens on EgressProxyAddr and relays every connection over a unix socket
+// to this proxy, which runs in the supervisor on the container network and
+// only lets CONNECT and plain HTTP requests through to allowed hosts.
+//
+// This contains tools that honour the proxy, not a hostile user: the shell
+// still runs as tazpod, whose sudo rights can enter any namespace.
+
+const EgressProxyAddr = "127.0.0.1:3128"
+
+// EgressLogFile records every denied connection, one per line.
+const EgressLogFile = "/workspace/.tazpod/egress-denied.log"
+
+type egressProxy struct {
+	allow    []string
+	socket   string
+	listener net.Listener
+	client   *http.Client
+
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+// ghostProxy is the egress proxy of the current ghost session, if isolated.
+var ghostProxy *egressProxy
+
+func networkIsolated() bool {
+	switch cfg.Ghost.Network.Policy {
+	case "", "host":
+		return false
+	case "isolated":
+		return true
+	}
+	fmt.Printf("❌ Unknown ghost.network.policy %q (use host or isolated).\n", cfg.Ghost.Network.Policy)
+	os.Exit(1)
+	return false
+}
+
+// egressAllowlist is the configured list plus the secret backends pull needs.
+func egressAllowlist() []string {
+	allow := []string{infisicalHost()}
+	if host := vaultHost(); host != "" { allow = append(allow, host) }
+	return append(allow, cfg.Ghost.Network.Allow...)
+}
+
+// proxyEnv points HTTP clients in the isolated shell at the local relay.
+func proxyEnv() []string {
+	url := "http://" + EgressProxyAddr
+	return []string{"HTTP_PROXY=" + url, "HTTPS_PROXY=" + url, "http_proxy=" + url, "https_proxy=" + url, "NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1"}
+}
+
+func startEgressProxy(sess *session) (*egressProxy, error) {
+	if sess == nil { return nil, fmt.Errorf("no session registry") }
+	p := &egressProxy{allow: egressAllowlist(), socket: filepath.Join(SessionDir, sess.ID+".proxy"), warned: map[string]bool{}}
+	os.Remove(p.socket)
+	l, err := net.Listen("unix", p.socket)
+	if err != nil { return nil, err }
+	os.Chmod(p.socket, 0600)
+	p.listener = l
+	// Connections are our own: never chain to a proxy from the environment
+	p.client = &http.Client{Transport: &http.Transport{Proxy: nil, DialContext: (&net.Dialer{Timeout: 10 * time.Second}).DialContext}, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	srv := &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+	go srv.Serve(l)
+	logDebug("Egress proxy on %s allows %v", p.socket, p.allow)
+	return p, nil
+}
+
+// stop closes the proxy. Nil-safe.
+func (p *egressProxy) stop() {
+	if p == nil { return }
+	p.listener.Close()
+	os.Remove(p.socket)
+}
+
+// allowed matches "host", "host:port" and "*.domain" rules, case-insensitive.
+// A rule without a port allows every port on that host.
+func (p *egressProxy) allowed(target string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil { host, port = target, "" }
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, rule := range p.allow {
+		rh, rp, err := net.SplitHostPort(rule)
+		if err != nil { rh, rp = rule, "" }
+		if rp != "" && rp != port { continue }
+		rh = strings.ToLower(rh)
+		if rh == host || (strings.HasPrefix(rh, "*.") && strings.HasSuffix(host, rh[1:])) { return true }
+	}
+	return false
+}
+
+func (p *egressProxy) deny(target string) {
+	if f, err := os.OpenFile(EgressLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err == nil {
+		fmt.Fprintf(f, "%s DENY %s\n", time.Now().UTC().Format(time.RFC3339), target)
+		f.Close()
+	}
+	p.mu.Lock()
+	first := !p.warned[target]
+	p.warned[target] = true
+	p.mu.Unlock()
+	if first { fmt.Fprintf(os.Stderr, "\r\n🚫 Egress to %s blocked (not in ghost.network.allow).\r\n", target) }
+}
+
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Host
+	if r.Method != http.MethodConnect {
+		if r.URL.Scheme != "http" || r.URL.Host == "" { http.Error(w, "tazpod: only proxy requests are served", http.StatusBadRequest); return }
+		target = r.URL.Host
+		if r.URL.Port() == "" { target = net.JoinHostPort(r.URL.Hostname(), "80") }
+	}
+	if !p.allowed(target) {
+		p.deny(target)
+		http.Error(w, "tazpod: egress to "+target+" is not allowed", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodConnect { p.tunnel(w, target); return }
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection"); out.Header.Del("Proxy-Authorization")
+	resp, err := p.client.Do(out)
+	if err != nil { http.Error(w, err.Error(), http.StatusBadGateway); return }
+	defer resp.Body.Close()
+	for k, v := range resp.Header { w.Header()[k] = v }
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *egressProxy) tunnel(w http.ResponseWriter, target string) {
+	dst, err := net.DialTimeout("tcp", target, 10*time.Second)
+	if err != nil { http.Error(w, err.Error(), http.StatusBadGateway); return }
+	hj, ok := w.(http.Hijacker)
+	if !ok { dst.Close(); http.Error(w, "hijacking unsupported", http.StatusInternalServerError); return }
+	src, buf, err := hj.Hijack()
+	if err != nil { dst.Close(); return }
+	fmt.Fprint(src, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	relay(&bufferedConn{src, buf.Reader}, dst)
+}
+
+// bufferedConn keeps bytes the HTTP server already read past the headers.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// relay copies both ways until either side is done, then closes both.
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(a, b); done <- struct{}{} }()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+	a.Close(); b.Close()
+}
+
+// forwardToProxy accepts TCP connections inside the isolated namespace and
+// hands each one to the supervisor's proxy socket.
+func forwardToProxy(l net.Listener, socket string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil { return }
+		go func() {
+			upstream, err := net.Dial("unix", socket)
+			if err != nil { conn.Close(); return }
+			relay(conn, upstream)
+		}()
+	}
+}
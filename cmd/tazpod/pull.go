@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- TRANSACTIONAL PULL ---
+//
+// A pull first fetches everything into memory, then stages it in a directory
+// inside the vault. Only when every required mapping succeeded are the staged
+// files moved over the old ones, each with an atomic rename and the
+// directories fsynced; if a rename fails half way, the files already replaced
+// are put back. Mappings marked 'optional: true' may fail without aborting the
+// pull and keep their previous contents. EnvFile is only replaced when every
+// provider export succeeded.
+
+const (
+	// StagingPrefix names the staging directories a pull creates in the vault.
+	StagingPrefix = ".pull-staging-"
+	// PullStateFile records the hash of every file as last pulled, so 'push'
+	// can tell an upstream change from a local one.
+	PullStateFile = MountPath + "/.pull-state.json"
+)
+
+// pullSet is everything one pull fetched, before any of it reaches the vault.
+type pullSet struct {
+	env       string
+	results   []fetchResult // one per mapping, in secrets.yml order
+	exports   []byte        // provider exports for EnvFile
+	exportsOK bool
+}
+
+// fetchPull fetches the active environment into memory and prints the
+// summary. It fails when a required mapping failed or nothing came back;
+// when only comparing, a secret deleted upstream is a removal, not a failure.
+func fetchPull(compare bool) (*pullSet, error) {
+	env, source := activeEnv()
+	fmt.Printf("📦 Fetching secrets from %s (%s)...\n", env, source)
+	ctx := context.Background()
+	start := time.Now()
+
+	// An export is required when a required mapping uses the same provider
+	providers := usedProviders()
+	required := map[string]bool{}
+	for _, s := range secCfg.Secrets {
+		if !s.Optional { required[providerName(s)] = true }
+	}
+
+	// One export per provider, then one job per mapping
+	job := func(i int) fetchResult {
+		if i < len(providers) {
+			r := fetchResult{label: "(" + env + " env)", provider: providers[i], optional: !required[providers[i]]}
+			p, err := providerFor(providers[i])
+			if err == nil { r.value, err = p.Export(ctx) }
+			r.err = err
+			return r
+		}
+		s := secCfg.Secrets[i-len(providers)]
+		r := fetchResult{label: s.Name, provider: providerName(s), optional: s.Optional}
+		if s.Environment != "" && s.Environment != env { r.label += "@" + s.Environment }
+		spec, err := resolveMapping(s)
+		if err != nil { r.err = err; return r }
+		r.target = spec.target
+		p, err := providerFor(r.provider)
+		if err == nil { r.value, err = p.Get(ctx, s) }
+		if err == nil { r.value, err = renderMapping(s, r.value) }
+		if err == nil && len(strings.TrimSpace(string(r.value))) == 0 { err = fmt.Errorf("empty") }
+		r.err = err
+		return r
+	}
+
+	// sops mappings go last: they decrypt with the age identity fetched by
+	// this same pull, which only reaches the vault at commit
+	var first, sops []int
+	for i := 0; i < len(providers)+len(secCfg.Secrets); i++ {
+		if i >= len(providers) && providerName(secCfg.Secrets[i-len(providers)]) == "sops" { sops = append(sops, i) } else { first = append(first, i) }
+	}
+	results := make([]fetchResult, len(providers)+len(secCfg.Secrets))
+	run := func(jobs []int) {
+		for k, r := range fetchAll(len(jobs), func(k int) fetchResult { return job(jobs[k]) }) { results[jobs[k]] = r }
+	}
+	run(first)
+	if len(sops) > 0 {
+		for _, r := range results {
+			if r.err == nil && r.target == sopsAgeKey() { setSopsIdentity(r.value) }
+		}
+		run(sops)
+	}
+
+	set := &pullSet{env: env, results: results[len(providers):], exportsOK: true}
+	var rows []fetchResult
+	var failed []string
+	fetched := 0
+	for i, r := range results {
+		if r.err != nil && !r.optional && !(compare && r.removed()) { failed = append(failed, r.label) }
+		if i < len(providers) {
+			if r.err != nil { set.exportsOK = false }
+			set.exports = append(set.exports, r.value...)
+			// Providers without an export have nothing to report on that row
+			if r.err == nil && len(r.value) == 0 { continue }
+		}
+		if r.err == nil || (compare && r.removed()) { fetched++ }
+		rows = append(rows, r)
+	}
+	printSummary(rows, time.Since(start))
+
+	if len(failed) > 0 { return nil, fmt.Errorf("%s failed", strings.Join(failed, ", ")) }
+	if fetched == 0 && len(rows) > 0 { return nil, fmt.Errorf("nothing could be fetched") }
+	return set, nil
+}
+
+func syncSecrets() error {
+	set, err := fetchPull(false)
+	if err != nil {
+		fmt.Printf("❌ Pull aborted: %v. The vault keeps its previous secrets.\n", err)
+		return err
+	}
+
+	txn, err := beginPull()
+	if err != nil { fmt.Printf("❌ Cannot stage secrets: %v\n", err); return err }
+	defer txn.discard()
+	for i, r := range set.results {
+		if r.err != nil { continue }
+		spec, _ := resolveMapping(secCfg.Secrets[i])
+		if err = txn.stageAs(spec, r.value); err != nil { break }
+	}
+	if err == nil && set.exportsOK && len(set.exports) > 0 { err = txn.stage(EnvFile, set.exports) }
+	if err == nil { err = txn.stage(ActiveEnvFile, []byte(set.env+"\n")) }
+	if err == nil {
+		// Failed optional mappings keep their file, and so their hash
+		state := loadPullState()
+		for _, r := range set.results {
+			if r.err == nil { state.set(r.target, r.value) }
+		}
+		var data []byte
+		if data, err = json.MarshalIndent(state, "", "  "); err == nil { err = txn.stage(PullStateFile, data) }
+	}
+	if err == nil { err = txn.commit() }
+	if err != nil {
+		fmt.Printf("❌ Pull rolled back: %v. The vault keeps its previous secrets.\n", err)
+		return err
+	}
+	if !set.exportsOK { fmt.Printf("⚠️  %s kept from the previous pull.\n", filepath.Base(EnvFile)) }
+	return nil
+}
+
+// pullState maps vault files, relative to MountPath, to their SHA-256.
+type pullState map[string]string
+
+func loadPullState() pullState {
+	state := pullState{}
+	if data, err := os.ReadFile(PullStateFile); err == nil { json.Unmarshal(data, &state) }
+	return state
+}
+
+func (s pullState) set(target string, value []byte) {
+	sum := sha256.Sum256(value)
+	s[strings.TrimPrefix(target, MountPath+"/")] = hex.EncodeToString(sum[:])
+}
+
+// matches reports whether value is what the last pull wrote to target.
+func (s pullState) matches(target string, value []byte) (known, same bool) {
+	hash, ok := s[strings.TrimPrefix(target, MountPath+"/")]
+	if !ok { return false, false }
+	sum := sha256.Sum256(value)
+	return true, hash == hex.EncodeToString(sum[:])
+}
+
+type pullTxn struct {
+	dir     string   // staging directory inside the vault
+	staged  []string // staged files, in order
+	targets []string // where each staged file goes
+}
+
+// beginPull creates a staging directory, removing any left by a pull that
+// was killed half way.
+func beginPull() (*pullTxn, error) {
+	if old, _ := filepath.Glob(filepath.Join(MountPath, StagingPrefix+"*")); len(old) > 0 {
+		for _, dir := range old { os.RemoveAll(dir) }
+	}
+	dir, err := os.MkdirTemp(MountPath, StagingPrefix)
+	if err != nil { return nil, err }
+	return &pullTxn{dir: dir}, nil
+}
+
+// stage writes a file of our own, 0600 and owned by tazpod.
+func (t *pullTxn) stage(target string, data []byte) error {
+	return t.stageAs(mappingSpec{target: target, perm: 0600, uid: TazPodUID, gid: TazPodGID}, data)
+}
+
+// stageAs writes a mapping's file with its permissions and owner.
+func (t *pullTxn) stageAs(spec mappingSpec, data []byte) error {
+	path := filepath.Join(t.dir, strconv.Itoa(len(t.staged)))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil { return err }
+	if _, err := f.Write(data); err != nil { f.Close(); return err }
+	if err := f.Chmod(spec.perm); err != nil { f.Close(); return err }
+	if err := f.Chown(spec.uid, spec.gid); err != nil {
+		if spec.owner { f.Close(); return fmt.Errorf("owner of %s: %w", filepath.Base(spec.target), err) }
+		logDebug("chown %s: %v", path, err)
+	}
+	if err := f.Sync(); err != nil { f.Close(); return err }
+	if err := f.Close(); err != nil { return err }
+	t.staged = append(t.staged, path)
+	t.targets = append(t.targets, spec.target)
+	return nil
+}
+
+// commit renames every staged file over its target. The old files are hard
+// linked into the staging directory first, so a failure can put them back.
+func (t *pullTxn) commit() error {
+	backups := filepath.Join(t.dir, "previous")
+	if err := os.Mkdir(backups, 0700); err != nil { return err }
+	type applied struct {
+		target, backup string
+		existed        bool
+	}
+	var done []applied
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			if done[i].existed { os.Rename(done[i].backup, done[i].target) } else { os.Remove(done[i].target) }
+		}
+	}
+	dirs := map[string]bool{}
+	for i, staged := range t.staged {
+		a := applied{target: t.targets[i], backup: filepath.Join(backups, strconv.Itoa(i))}
+		mkdirOwned(filepath.Dir(a.target), TazPodUID, TazPodGID)
+		if err := os.Link(a.target, a.backup); err == nil {
+			a.existed = true
+		} else if !os.IsNotExist(err) {
+			rollback(); return err
+		}
+		if err := os.Rename(staged, a.target); err != nil { rollback(); return err }
+		done = append(done, a)
+		dirs[filepath.Dir(a.target)] = true
+	}
+	for dir := range dirs {
+		if d, err := os.Open(dir); err == nil { d.Sync(); d.Close() }
+	}
+	return nil
+}
+
+// discard removes the staging directory and with it the old file versions.
+func (t *pullTxn) discard() { os.RemoveAll(t.dir) }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- PUSH ---
+//
+// 'tazpod push [name...]' sends mapped files from the vault back to their
+// provider, e.g. a kubeconfig regenerated inside the enclave. It compares
+// each file with the current upstream value and with the hash recorded by
+// the last pull (PullStateFile): a secret that changed upstream since then
+// is a conflict and is only overwritten with --force. The plan lists names
+// and line counts, never values, and is confirmed before anything is sent
+// unless --yes is given. Files derived with jsonpath, yamlpath or a template
+// cannot be turned back into the upstream value and are skipped.
+
+type pushItem struct {
+	m        SecretMapping
+	target   string
+	local    []byte
+	create   bool
+	conflict string // why pushing would lose an upstream change
+}
+
+func push() {
+	if os.Getenv(GhostEnvVar) != "true" { fmt.Println("❌ Vault is closed. Run 'tazpod unlock' first."); os.Exit(1) }
+	names := positional()
+	mappings, err := pushMappings(names)
+	if err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
+	internalEnsureAuth()
+	ctx := context.Background()
+	state := loadPullState()
+
+	var items []pushItem
+	failed, conflicts := 0, 0
+	fmt.Printf("🔍 Comparing %d secret(s) with their providers...\n", len(mappings))
+	for _, m := range mappings {
+		it := pushItem{m: m}
+		label := fmt.Sprintf("%s (%s)", m.Name, providerName(m))
+		p, err := providerFor(providerName(m))
+		var spec mappingSpec
+		if err == nil { spec, err = resolveMapping(m) }
+		if err == nil { it.target = spec.target; it.local, err = os.ReadFile(it.target) }
+		if err != nil { fmt.Printf("   ❌ %s: %v\n", label, err); failed++; continue }
+		skip := ""
+		if _, ok := p.(secretPusher); !ok { skip = "the provider cannot push" } else if m.derived() { skip = "the file is derived with jsonpath, yamlpath or template" }
+		if skip != "" {
+			if len(names) == 0 { fmt.Printf("   ℹ️  %s: skipped, %s\n", label, skip); continue }
+			fmt.Printf("   ❌ %s: %s\n", label, skip); failed++; continue
+		}
+
+		// Compare file contents: upstream as a pull would write it
+		upstream, err := p.Get(ctx, m)
+		var miss *missingError
+		if err == nil { upstream, err = renderMapping(m, upstream) }
+		if errors.As(err, &miss) {
+			it.create = true
+		} else if err != nil {
+			fmt.Printf("   ❌ %s: %v\n", label, err); failed++; continue
+		} else if string(upstream) == string(it.local) {
+			fmt.Printf("   ✅ %s: up to date\n", label); continue
+		}
+
+		// Upstream should still be what the last pull wrote here
+		known, same := state.matches(it.target, upstream)
+		switch {
+		case it.create:
+		case !known: it.conflict = "never pulled, upstream has a value"
+		case !same: it.conflict = "changed upstream since the last pull"
+		}
+		if it.conflict != "" { conflicts++ }
+		switch {
+		case it.create: fmt.Printf("   + %s: new, %d lines\n", label, lineCount(it.local))
+		default:
+			added, removed := lineDelta(upstream, it.local)
+			fmt.Printf("   ~ %s: +%d -%d lines", label, added, removed)
+			if it.conflict != "" { fmt.Print("  ⚠️  " + it.conflict) }
+			fmt.Println()
+		}
+		items = append(items, it)
+	}
+
+	if conflicts > 0 && !hasFlag("--force") {
+		fmt.Println("❌ Upstream changes would be lost. Run 'tazpod pull --dry-run' to inspect, or push with --force.")
+		os.Exit(1)
+	}
+	if len(items) == 0 {
+		fmt.Println("✅ Nothing to push.")
+		if failed > 0 { os.Exit(1) }
+		return
+	}
+	if !hasFlag("--yes") {
+		fmt.Printf("⚠️  Push %d secret(s)? (y/N): ", len(items)); var c string; fmt.Scanln(&c)
+		if strings.ToLower(c) != "y" { fmt.Println("Aborted."); os.Exit(1) }
+	}
+
+	pushed := 0
+	for _, it := range items {
+		p, _ := providerFor(providerName(it.m))
+		value, err := unrenderMapping(it.m, it.local)
+		if err == nil { err = p.(secretPusher).Put(ctx, it.m, value) }
+		if err != nil {
+			fmt.Printf("   ❌ %s: %v\n", it.m.Name, err); failed++; continue
+		}
+		fmt.Printf("   ✅ %s pushed\n", it.m.Name)
+		state.set(it.target, it.local)
+		pushed++
+	}
+	if pushed > 0 {
+		if err := savePullState(state); err != nil { fmt.Printf("⚠️  Cannot record the pushed hashes: %v\n", err) }
+	}
+	fmt.Printf("📊 %d pushed, %d failed\n", pushed, failed)
+	if failed > 0 { os.Exit(1) }
+}
+
+// pushMappings picks mappings by name or file, all of them when none is given.
+func pushMappings(names []string) ([]SecretMapping, error) {
+	if len(names) == 0 { return secCfg.Secrets, nil }
+	var out []SecretMapping
+	for _, name := range names {
+		found := false
+		for _, m := range secCfg.Secrets {
+			if m.Name == name || mappingFile(m) == name { out = append(out, m); found = true }
+		}
+		if !found { return nil, fmt.Errorf("no mapping named %s in secrets.yml", name) }
+	}
+	return out, nil
+}
+
+// savePullState replaces PullStateFile through a one-file pull transaction.
+func savePullState(state pullState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil { return err }
+	txn, err := beginPull()
+	if err != nil { return err }
+	defer txn.discard()
+	if err := txn.stage(PullStateFile, data); err != nil { return err }
+	return txn.commit()
+}
+
+func lineCount(data []byte) int { return len(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")) }
+
+// lineDelta counts lines only in b (added) and only in a (removed),
+// ignoring order; enough to size a change without showing it.
+func lineDelta(a, b []byte) (added, removed int) {
+	seen := map[string]int{}
+	for _, l := range strings.Split(string(a), "\n") { seen[l]++ }
+	for _, l := range strings.Split(string(b), "\n") {
+		if seen[l] > 0 { seen[l]-- } else { added++ }
+	}
+	for _, n := range seen { removed += n }
+	return added, removed
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- GHOST SESSIONS ---
+//
+// Every ghost supervisor registers itself under SessionDir with a JSON record
+// and a control socket owned by the tazpod user. Clients talk to it with
+// one-line text commands ("lock") and get a one-line answer ("ok" or an error).
+// "attach" is the exception: the connection stays open for as long as the
+// attached shell lives, and the supervisor uses it to ask that shell to lock.
+
+const (
+	SessionDir    = "/run/tazpod"
+	SessionEnvVar = "TAZPOD_SESSION"
+	ExitLocked    = 3 // ghost session ended by 'tazpod lock'
+)
+
+type session struct {
+	ID      string    `json:"id"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Vault   string    `json:"vault,omitempty"`
+	Mapper  string    `json:"mapper,omitempty"`
+	Loop    string    `json:"loop,omitempty"`
+	NetPID  int       `json:"net_pid,omitempty"` // holder of the isolated network namespace
+	Env     string    `json:"env,omitempty"`     // secrets environment shown in the prompt
+
+	listener net.Listener
+	lockFile *os.File
+	lockReq  chan struct{}
+	bridges  []bridgeSpec // bound by setupBindAuth, undone at teardown
+
+	mu       sync.Mutex
+	env       []string
+	attached  map[net.Conn]bool
+	detached  *sync.Cond
+	lastInput time.Time // latest input reported by an attached shell
+}
+
+func (s *session) recordPath() string { return filepath.Join(SessionDir, s.ID+".json") }
+func (s *session) socketPath() string { return filepath.Join(SessionDir, s.ID+".sock") }
+
+func newSessionID() string { b := make([]byte, 4); rand.Read(b); return hex.EncodeToString(b) }
+
+// startSession registers the calling supervisor and starts serving its socket.
+func startSession() (*session, error) {
+	s := &session{ID: newSessionID(), PID: os.Getpid(), Started: time.Now(), lockReq: make(chan struct{}, 1), attached: map[net.Conn]bool{}}
+	s.detached = sync.NewCond(&s.mu)
+	if err := os.MkdirAll(SessionDir, 0755); err != nil { return nil, err }
+	if err := s.save(); err != nil { return nil, err }
+	l, err := net.Listen("unix", s.socketPath())
+	if err != nil { os.Remove(s.recordPath()); return nil, err }
+	os.Chown(s.socketPath(), TazPodUID, TazPodGID); os.Chmod(s.socketPath(), 0600)
+	s.listener = l
+	go s.serve()
+	return s, nil
+}
+
+func (s *session) save() error {
+	data, _ := json.MarshalIndent(s, "", "  ")
+	return writeFileAtomic(s.recordPath(), data, 0644)
+}
+
+func (s *session) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil { return }
+		go s.handle(conn)
+	}
+}
+
+func (s *session) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil { return }
+	switch strings.TrimSpace(line) {
+	case "lock":
+		fmt.Fprintln(conn, "ok")
+		select { case s.lockReq <- struct{}{}: default: }
+	case "attach":
+		// Only the root helper that already joined our namespace may attach
+		if uid, err := peerUID(conn); err != nil || uid != 0 { fmt.Fprintln(conn, "error: permission denied"); return }
+		s.mu.Lock()
+		if s.env == nil { s.mu.Unlock(); fmt.Fprintln(conn, "error: session is not ready"); return }
+		env, _ := json.Marshal(s.env)
+		s.attached[conn] = true
+		s.mu.Unlock()
+		fmt.Fprintln(conn, "ok "+base64.StdEncoding.EncodeToString(env))
+		// The attached side reports "active" while its terminal gets input
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil { break }
+			if strings.TrimSpace(line) == "active" { s.mu.Lock(); s.lastInput = time.Now(); s.mu.Unlock() }
+		}
+		s.mu.Lock()
+		delete(s.attached, conn)
+		s.detached.Broadcast()
+		s.mu.Unlock()
+	default:
+		fmt.Fprintln(conn, "error: unknown command")
+	}
+}
+
+func (s *session) setEnv(env []string) { s.mu.Lock(); s.env = env; s.mu.Unlock() }
+
+// attachedIdle returns how long ago an attached shell last reported input.
+func (s *session) attachedIdle() (time.Duration, bool) {
+	if s == nil { return 0, false }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastInput.IsZero() { return 0, false }
+	return time.Since(s.lastInput), true
+}
+
+// lockAttached asks every attached shell to end.
+func (s *session) lockAttached() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.attached { fmt.Fprintln(conn, "lock") }
+}
+
+// waitAttached blocks until the last attached shell has exited.
+func (s *session) waitAttached() {
+	if s == nil { return }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.attached); n > 0 { fmt.Printf("⏳ Waiting for %d attached shell(s) to exit before locking...\n", n) }
+	for len(s.attached) > 0 { s.detached.Wait() }
+}
+
+// currentSession is the session this supervisor runs, nil outside internal-ghost.
+var currentSession *session
+
+// vaultLock holds the flock taken by claimVault even without a session, so
+// the *os.File is never collected and its finalizer cannot release the lock.
+var vaultLock *os.File
+
+// claimVault takes an exclusive lock on the vault for the whole session and
+// names the mapper after the session. Two sessions writing to the same ext4
+// image would corrupt it, so a second unlock is refused and pointed at attach.
+func claimVault(s *session) {
+	path := backend().Path()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil { fmt.Printf("❌ Cannot lock vault: %v\n", err); os.Exit(1) }
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		for _, other := range listSessions() {
+			if other.Vault == path && (s == nil || other.ID != s.ID) {
+				fmt.Printf("❌ Vault already open in session %s (pid %d). Use 'tazpod attach %s' instead.\n", other.ID, other.PID, other.ID)
+				s.close(); os.Exit(1)
+			}
+		}
+		fmt.Println("❌ Vault is already open in another session."); s.close(); os.Exit(1)
+	}
+	vaultLock = f
+	if s == nil { return }
+	s.lockFile = f
+	s.Vault, s.Mapper = path, MapperName+"_"+s.ID
+	mapperName = s.Mapper
+	s.save()
+}
+
+// reapSessions cleans up after supervisors that died without a teardown
+// (SIGKILL, OOM killer, container restart): their mapper and loop device stay
+// open in the kernel until someone closes them. Only devices recorded by this
+// container's own sessions are touched.
+func reapSessions() {
+	paths, _ := filepath.Glob(filepath.Join(SessionDir, "*.json"))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		s := &session{}
+		if err != nil || json.Unmarshal(data, s) != nil || s.ID == "" || processAlive(s.PID) { continue }
+		fmt.Printf("🧹 Closing devices left open by dead session %s...\n", s.ID)
+		if s.Mapper != "" { closeMapper(s.Mapper) }
+		// The loop may have been released and reused by now
+		if s.Loop != "" && loopBacking(s.Loop) == s.Vault {
+			if err := detachLoop(s.Loop); err != nil { fmt.Printf("⚠️  %v\n", err) }
+		}
+		s.close()
+	}
+}
+
+// processAlive reports whether pid still exists.
+func processAlive(pid int) bool { return pid > 0 && syscall.Kill(pid, 0) != syscall.ESRCH }
+
+func (s *session) setBridges(specs []bridgeSpec) {
+	if s == nil { return }
+	s.mu.Lock(); s.bridges = specs; s.mu.Unlock()
+}
+
+func (s *session) takeBridges() []bridgeSpec {
+	if s == nil { return nil }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	specs := s.bridges
+	s.bridges = nil
+	return specs
+}
+
+// close unregisters the session. Safe to call more than once.
+func (s *session) close() {
+	if s == nil { return }
+	if s.listener != nil { s.listener.Close() }
+	if s.lockFile != nil { s.lockFile.Close() }
+	os.Remove(s.socketPath()); os.Remove(s.recordPath()); os.Remove(filepath.Join(SessionDir, s.ID+".proxy"))
+}
+
+// listSessions returns the registered sessions, oldest first.
+func listSessions() []*session {
+	var out []*session
+	paths, _ := filepath.Glob(filepath.Join(SessionDir, "*.json"))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil { continue }
+		s := &session{}
+		if json.Unmarshal(data, s) == nil && s.ID != "" && processAlive(s.PID) { out = append(out, s) }
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+// sendSession delivers a control command and returns the supervisor's answer.
+func sendSession(id, command string) (string, error) {
+	conn, err := net.DialTimeout("unix", filepath.Join(SessionDir, id+".sock"), 2*time.Second)
+	if err != nil { return "", err }
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintln(conn, command)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil { return "", err }
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "error: ") { return "", fmt.Errorf("%s", strings.TrimPrefix(reply, "error: ")) }
+	return reply, nil
+}
+
+// selectSession resolves the session named on the command line, or the only
+// open one. It exits with a listing when the choice is ambiguous.
+func selectSession(alternative string) *session {
+	sessions := listSessions()
+	if ids := positional(); len(ids) > 0 {
+		for _, s := range sessions { if s.ID == ids[0] { return s } }
+		fmt.Printf("❌ No ghost session %s.\n", ids[0]); os.Exit(1)
+	}
+	if len(sessions) > 1 {
+		hint := ""
+		if alternative != "" { hint = " or use " + alternative }
+		fmt.Printf("❌ Several ghost sessions are open, pick one%s:\n", hint)
+		for _, s := range sessions { fmt.Printf("   %s  (since %s)\n", s.ID, s.Started.Format("15:04:05")) }
+		os.Exit(1)
+	}
+	if len(sessions) == 0 { return nil }
+	return sessions[0]
+}
+
+// --- LOCK ---
+
+func lock() {
+	var targets []string
+	switch {
+	case hasFlag("--all"):
+		for _, s := range listSessions() { targets = append(targets, s.ID) }
+	case len(positional()) > 0:
+		targets = positional()[:1]
+	case os.Getenv(SessionEnvVar) != "":
+		targets = []string{os.Getenv(SessionEnvVar)}
+	default:
+		if s := selectSession("--all"); s != nil { targets = []string{s.ID} }
+	}
+	if len(targets) == 0 { fmt.Println("ℹ️  No open ghost sessions."); return }
+
+	failed := false
+	for _, id := range targets {
+		if _, err := sendSession(id, "lock"); err != nil {
+			fmt.Printf("❌ Session %s: %v\n", id, err); failed = true
+		} else {
+			fmt.Printf("🔒 Session %s is locking.\n", id)
+		}
+	}
+	if failed { os.Exit(1) }
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// --- SESSION SSH AGENT ---
+//
+// SSH keys live in the vault under SSHKeysDir. When a ghost session has keys
+// to load, the supervisor serves an in-memory ssh-agent on a socket inside a
+// tmpfs that only exists in the ghost namespace, exports SSH_AUTH_SOCK to the
+// shell and drops every key on lock. Encrypted keys are opened with the
+// passphrase stored next to them in the vault.
+
+const (
+	SSHKeysDir       = MountPath + "/.ssh-keys"
+	SSHAgentDir      = "/run/tazpod-ssh"
+	PassphraseSuffix = ".passphrase"
+)
+
+type SSHKey struct {
+	File           string `yaml:"file"`            // relative to the vault's .ssh-keys directory
+	PassphraseFile string `yaml:"passphrase_file"` // default <file>.passphrase next to the key
+	Lifetime       string `yaml:"lifetime"`        // drop the key from the agent after e.g. "1h"
+}
+
+type sshAgent struct {
+	keyring  sshagent.Agent
+	listener net.Listener
+	socket   string
+}
+
+// ghostSSH is the agent of the current ghost session, nil when it has no keys.
+var ghostSSH *sshAgent
+
+// vaultKeyPath resolves a key file name and refuses anything outside the vault.
+func vaultKeyPath(name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) { path = filepath.Join(SSHKeysDir, name) }
+	path = filepath.Clean(path)
+	if !strings.HasPrefix(path, MountPath+"/") { return "", fmt.Errorf("%s is outside the vault", name) }
+	return path, nil
+}
+
+// sshKeys returns the configured keys, or every key pair found in SSHKeysDir.
+func sshKeys() []SSHKey {
+	if len(cfg.SSH.Keys) > 0 { return cfg.SSH.Keys }
+	var keys []SSHKey
+	pubs, _ := filepath.Glob(filepath.Join(SSHKeysDir, "*.pub"))
+	for _, pub := range pubs {
+		if priv := strings.TrimSuffix(pub, ".pub"); fileExist(priv) { keys = append(keys, SSHKey{File: filepath.Base(priv)}) }
+	}
+	return keys
+}
+
+// startSSHAgent serves the session agent and loads the vault keys into it.
+func startSSHAgent() (*sshAgent, error) {
+	keys := sshKeys()
+	if len(keys) == 0 { return nil, nil }
+	os.MkdirAll(SSHAgentDir, 0700)
+	if err := mountFS("tazpod_ssh", SSHAgentDir, "tmpfs", fmt.Sprintf("size=1m,mode=0700,uid=%d,gid=%d", TazPodUID, TazPodGID)); err != nil { return nil, err }
+	a := &sshAgent{keyring: sshagent.NewKeyring(), socket: filepath.Join(SSHAgentDir, "agent.sock")}
+	l, err := net.Listen("unix", a.socket)
+	if err != nil { unmount(SSHAgentDir); return nil, err }
+	os.Chown(a.socket, TazPodUID, TazPodGID); os.Chmod(a.socket, 0600)
+	a.listener = l
+	go a.serve()
+
+	fmt.Println("🔑 Loading SSH keys into the session agent...")
+	for _, k := range keys {
+		if err := a.load(k); err != nil { fmt.Printf("  ⚠️  %s: %v\n", k.File, err) } else { fmt.Printf("  ✅ %s\n", k.File) }
+	}
+	return a, nil
+}
+
+func (a *sshAgent) serve() {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil { return }
+		go func() {
+			defer conn.Close()
+			if uid, err := peerUID(conn); err != nil || (uid != 0 && uid != TazPodUID) { return }
+			sshagent.ServeAgent(a.keyring, conn)
+		}()
+	}
+}
+
+func (a *sshAgent) load(k SSHKey) error {
+	path, err := vaultKeyPath(k.File)
+	if err != nil { return err }
+	passFile := k.PassphraseFile
+	if passFile == "" { passFile = path + PassphraseSuffix }
+	if passFile, err = vaultKeyPath(passFile); err != nil { return err }
+
+	added, err := readVaultKey(path, passFile)
+	if err != nil { return err }
+	if secs := parseLimit(k.Lifetime, "ssh key lifetime").Seconds(); secs > 0 { added.LifetimeSecs = uint32(secs) }
+	return a.keyring.Add(added)
+}
+
+// readVaultKey parses a private key, using the passphrase file if it is encrypted.
+func readVaultKey(path, passFile string) (sshagent.AddedKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil { return sshagent.AddedKey{}, err }
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		pass, perr := os.ReadFile(passFile)
+		if perr != nil { return sshagent.AddedKey{}, fmt.Errorf("key is encrypted and %s is missing", filepath.Base(passFile)) }
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(strings.TrimRight(string(pass), "\r\n")))
+	}
+	if err != nil { return sshagent.AddedKey{}, err }
+	return sshagent.AddedKey{PrivateKey: key, Comment: keyComment(path)}, nil
+}
+
+// keyComment takes the comment from the .pub file, the file name otherwise.
+func keyComment(path string) string {
+	if data, err := os.ReadFile(path + ".pub"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 2 { return strings.Join(fields[2:], " ") }
+	}
+	return filepath.Base(path)
+}
+
+// stop forgets every key and removes the socket with its tmpfs. Nil-safe.
+func (a *sshAgent) stop() {
+	if a == nil { return }
+	a.keyring.RemoveAll()
+	a.listener.Close()
+	if err := unmount(SSHAgentDir); err != nil { logDebug("%v", err) }
+}
+
+// --- SSH-KEYS COMMAND ---
+
+func sshKeysCmd() {
+	if os.Getenv(GhostEnvVar) != "true" { fmt.Println("❌ Vault is closed. Run 'tazpod unlock' first."); os.Exit(1) }
+	sub, args := "list", positional()
+	if len(args) > 0 { sub, args = args[0], args[1:] }
+	switch {
+	case sub == "list":
+		sshKeysList()
+	case sub == "generate" && len(args) == 1:
+		sshKeysGenerate(args[0])
+	case sub == "add" && len(args) == 1:
+		sshKeysAdd(args[0])
+	default:
+		fmt.Println("Usage: tazpod ssh-keys [list] | generate <name> [--rsa] [--comment <c>] [--passphrase] | add <keyfile> [--name <n>] [--move]")
+		os.Exit(1)
+	}
+}
+
+func sshKeysList() {
+	loaded := map[string]bool{}
+	if client := sessionAgent(); client != nil {
+		if keys, err := client.List(); err == nil { for _, k := range keys { loaded[ssh.FingerprintSHA256(k)] = true } }
+	}
+	pubs, _ := filepath.Glob(filepath.Join(SSHKeysDir, "*.pub"))
+	if len(pubs) == 0 { fmt.Println("ℹ️  No SSH keys in the vault. Use 'tazpod ssh-keys generate <name>'."); return }
+	for _, p := range pubs {
+		data, _ := os.ReadFile(p)
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil { fmt.Printf("  ⚠️  %s: %v\n", filepath.Base(p), err); continue }
+		state := "  "
+		if loaded[ssh.FingerprintSHA256(pub)] { state = "🔑" }
+		fmt.Printf("%s %-20s %-12s %s %s\n", state, strings.TrimSuffix(filepath.Base(p), ".pub"), pub.Type(), ssh.FingerprintSHA256(pub), comment)
+	}
+}
+
+func sshKeysGenerate(name string) {
+	path, err := vaultKeyPath(name)
+	if err != nil || filepath.Dir(path) != SSHKeysDir { fmt.Println("❌ Key names are plain file names."); os.Exit(1) }
+	if fileExist(path) { fmt.Printf("❌ Key %s already exists.\n", name); os.Exit(1) }
+
+	var priv crypto.Signer
+	if hasFlag("--rsa") {
+		priv, err = rsa.GenerateKey(rand.Reader, 4096)
+	} else {
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil { fmt.Printf("❌ Key generation failed: %v\n", err); os.Exit(1) }
+	comment := flagValue("--comment")
+	if comment == "" { comment = "tazpod-" + name }
+	passphrase := ""
+	if hasFlag("--passphrase") { passphrase = readNewPassphrase("Define Key Passphrase") }
+	saveVaultKey(path, priv, comment, passphrase)
+	fmt.Printf("✅ Generated %s in the vault.\n", name)
+	data, _ := os.ReadFile(path + ".pub")
+	fmt.Print(string(data))
+	addToSessionAgent(path)
+}
+
+func sshKeysAdd(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
+	name := flagValue("--name")
+	if name == "" { name = filepath.Base(file) }
+	path, err := vaultKeyPath(name)
+	if err != nil || filepath.Dir(path) != SSHKeysDir { fmt.Println("❌ Key names are plain file names."); os.Exit(1) }
+	if fileExist(path) { fmt.Printf("❌ Key %s already exists.\n", name); os.Exit(1) }
+
+	passphrase := ""
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		fmt.Printf("🔑 Passphrase for %s: ", file); p, _ := term.ReadPassword(int(syscall.Stdin)); fmt.Println()
+		passphrase = string(p)
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, p)
+	}
+	if err != nil { fmt.Printf("❌ Not a usable private key: %v\n", err); os.Exit(1) }
+	if k, ok := key.(*ed25519.PrivateKey); ok { key = *k } // the OpenSSH encoder wants the value
+	signer, ok := key.(crypto.Signer)
+	if !ok { fmt.Println("❌ Unsupported key type."); os.Exit(1) }
+	comment := name
+	if pub, err := os.ReadFile(file + ".pub"); err == nil {
+		if fields := strings.Fields(string(pub)); len(fields) > 2 { comment = strings.Join(fields[2:], " ") }
+	}
+	saveVaultKey(path, signer, comment, passphrase)
+	fmt.Printf("✅ Added %s to the vault.\n", name)
+	if hasFlag("--move") {
+		os.Remove(file); os.Remove(file + ".pub")
+		fmt.Printf("🧹 Removed the plaintext copy at %s.\n", file)
+	} else {
+		fmt.Printf("⚠️  The plaintext copy at %s is still there, delete it or use --move.\n", file)
+	}
+	addToSessionAgent(path)
+}
+
+// saveVaultKey writes the OpenSSH key pair, plus the passphrase file that
+// lets the session agent open an encrypted key unattended.
+func saveVaultKey(path string, key crypto.Signer, comment, passphrase string) {
+	var block *pem.Block
+	var err error
+	if passphrase != "" { block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(passphrase)) } else { block, err = ssh.MarshalPrivateKey(key, comment) }
+	if err != nil { fmt.Printf("❌ Cannot encode key: %v\n", err); os.Exit(1) }
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil { fmt.Printf("❌ Cannot encode public key: %v\n", err); os.Exit(1) }
+
+	os.MkdirAll(SSHKeysDir, 0700)
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + " " + comment + "\n"
+	if err := writeFileAtomic(path, pem.EncodeToMemory(block), 0600); err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
+	if err := writeFileAtomic(path+".pub", []byte(authorized), 0644); err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
+	if passphrase != "" {
+		if err := writeFileAtomic(path+PassphraseSuffix, []byte(passphrase+"\n"), 0600); err != nil { fmt.Printf("❌ %v\n", err); os.Exit(1) }
+	}
+}
+
+// sessionAgent connects to the ghost session's agent, ignoring any other one.
+func sessionAgent() sshagent.ExtendedAgent {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if !strings.HasPrefix(sock, SSHAgentDir+"/") { return nil }
+	conn, err := net.Dial("unix", sock)
+	if err != nil { return nil }
+	return sshagent.NewClient(conn)
+}
+
+func addToSessionAgent(path string) {
+	client := sessionAgent()
+	if client == nil { fmt.Println("ℹ️  It will be loaded into the SSH agent on the next unlock."); return }
+	key, err := readVaultKey(path, path+PassphraseSuffix)
+	if err == nil { err = client.Add(key) }
+	if err != nil { fmt.Printf("⚠️  Could not load it into the session agent: %v\n", err); return }
+	fmt.Println("🔑 Loaded into the session SSH agent.")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- STATUS ---
+
+func status() {
+	fmt.Println("🛡️  TazPod status")
+	env, source := activeEnv()
+	if os.Getenv(GhostEnvVar) == "true" {
+		id := os.Getenv(SessionEnvVar)
+		mode := "vault"
+		if os.Getenv(EphemeralEnvVar) == "true" { mode = "ephemeral (RAM only)" }
+		fmt.Printf("   Ghost mode:   active, %s, session %s\n", mode, id)
+		fmt.Printf("   Environment:  %s (%s)\n", env, source)
+		if info, err := os.Stat(ActiveEnvFile); err == nil {
+			fmt.Printf("   Last pull:    %s at %s\n", pulledEnv(), info.ModTime().Format("2006-01-02 15:04"))
+		} else {
+			fmt.Println("   Last pull:    never")
+		}
+	} else {
+		fmt.Println("   Ghost mode:   inactive, vault closed")
+		fmt.Printf("   Environment:  %s (%s), used by the next pull\n", env, source)
+	}
+	fmt.Printf("   Providers:    %s\n", strings.Join(usedProviders(), ", "))
+	if networkIsolated() { fmt.Println("   Network:      isolated") }
+
+	sessions := listSessions()
+	if len(sessions) == 0 { return }
+	fmt.Println("   Sessions:")
+	for _, s := range sessions {
+		senv := s.Env
+		if senv == "" { senv = "-" }
+		fmt.Printf("     %s  env %-10s since %s\n", s.ID, senv, s.Started.Format("15:04:05"))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// --- GUARANTEED TEARDOWN ---
+//
+// A closed terminal, 'docker stop' or a crash must not leave the vault
+// decrypted. The supervisor traps signals for its whole life: before the
+// ghost shell runs it tears down and exits at once, while the shell runs it
+// hangs the shell up and lets the normal teardown follow. ^C and ^\ belong to
+// the shell, the terminal delivers them to it directly. SIGKILL cannot be
+// trapped, reapSessions cleans up after that on the next start.
+
+type supervisor struct {
+	sess *session
+	tty  *term.State
+	once sync.Once
+
+	mu    sync.Mutex // held for good once a pre-shell signal starts the teardown
+	shell *os.Process
+	done  <-chan struct{}
+}
+
+func supervise(sess *session) *supervisor {
+	s := &supervisor{sess: sess}
+	if state, err := term.GetState(int(os.Stdin.Fd())); err == nil { s.tty = state }
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
+	go s.handle(sigs)
+	return s
+}
+
+func (s *supervisor) handle(sigs <-chan os.Signal) {
+	for sig := range sigs {
+		s.mu.Lock()
+		if s.shell == nil {
+			// Still unlocking: the main goroutine may be anywhere, so never
+			// release mu and let os.Exit stop it
+			fmt.Fprintf(os.Stderr, "\r\n⚠️  Interrupted (%v), locking the vault...\r\n", sig)
+			if s.tty != nil { term.Restore(int(os.Stdin.Fd()), s.tty) }
+			s.teardown()
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		}
+		shell, done := s.shell, s.done
+		s.mu.Unlock()
+		if sig == syscall.SIGINT || sig == syscall.SIGQUIT { continue }
+		logDebug("Received %v, hanging up the ghost shell...", sig)
+		s.sess.lockAttached()
+		go terminateShell(shell, done)
+	}
+}
+
+// startShell starts cmd unless a signal already began the teardown, in which
+// case it blocks until the process exits.
+func (s *supervisor) startShell(cmd *exec.Cmd, done <-chan struct{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := cmd.Start(); err != nil { return err }
+	s.shell, s.done = cmd.Process, done
+	return nil
+}
+
+// teardown locks the vault exactly once, whoever gets there first.
+func (s *supervisor) teardown() { s.once.Do(func() { teardownGhost(s.sess) }) }
+
+// recoverPanic locks the vault before a crash takes the supervisor down.
+func (s *supervisor) recoverPanic() {
+	if r := recover(); r != nil {
+		fmt.Fprintf(os.Stderr, "❌ Supervisor crashed, locking the vault: %v\n", r)
+		s.teardown()
+		panic(r)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// --- NATIVE SYSTEM CALLS ---
+//
+// Ghost mode sets up namespaces, mounts, loop and device-mapper nodes with
+// direct system calls (sys_linux.go) instead of unshare, mount, losetup,
+// mknod and dmsetup. Only cryptsetup and the e2fsprogs stay external.
+
+// NamespaceEnvVar marks the re-executed supervisor that already runs in its
+// own mount namespace.
+const NamespaceEnvVar = "TAZPOD_NAMESPACE"
+
+var errUnsupported = errors.New("only supported on Linux")
+
+// sysError names the step of the ghost setup that failed and what it was
+// working on, so a failed unlock says more than "exit status 1".
+type sysError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *sysError) Error() string { return e.Op + " " + e.Path + ": " + e.Err.Error() }
+func (e *sysError) Unwrap() error { return e.Err }
+
+// relayChild runs cmd as a stand-in for the current process: it passes on
+// SIGTERM and SIGHUP, leaves ^C and ^\ to the child (the terminal delivers
+// them to it directly) and exits with the child's status. It only returns
+// when cmd cannot be started.
+func relayChild(cmd *exec.Cmd) error {
+	signal.Ignore(syscall.SIGINT, syscall.SIGQUIT)
+	if err := cmd.Start(); err != nil { return err }
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	go func() { for sig := range sigs { cmd.Process.Signal(sig) } }()
+	os.Exit(exitCode(cmd.Wait()))
+	return nil
+}
+
+// chownR hands a tree to the given owner without following symlinks, like chown -R.
+func chownR(root string, uid, gid int) error {
+	return filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil { return err }
+		if err := os.Lchown(path, uid, gid); err != nil { return &sysError{"chown", path, err} }
+		return nil
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// privateNamespace moves the supervisor into a new mount namespace with
+// private propagation, so nothing it mounts is visible outside. Go cannot
+// unshare a running multi-threaded process, so the binary re-executes itself
+// with CLONE_NEWNS and the parent only relays signals and the exit status.
+// It returns in the child, never in the parent.
+func privateNamespace() error {
+	if os.Getenv(NamespaceEnvVar) == "private" {
+		os.Unsetenv(NamespaceEnvVar)
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil { return &sysError{"make private", "/", err} }
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil { return &sysError{"locate", "executable", err} }
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), NamespaceEnvVar+"=private")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNS}
+	if err := relayChild(cmd); err != nil { return &sysError{"unshare", "mount namespace", err} }
+	return nil
+}
+
+// mountFS mounts like mount(8) -o options: ro/rw and the no* flags become
+// mount flags, everything else is passed to the filesystem.
+func mountFS(source, target, fstype, options string) error {
+	var flags uintptr
+	var data []string
+	for _, opt := range strings.Split(options, ",") {
+		switch opt {
+		case "", "rw":
+		case "ro": flags |= unix.MS_RDONLY
+		case "nosuid": flags |= unix.MS_NOSUID
+		case "nodev": flags |= unix.MS_NODEV
+		case "noexec": flags |= unix.MS_NOEXEC
+		default: data = append(data, opt)
+		}
+	}
+	if err := unix.Mount(source, target, fstype, flags, strings.Join(data, ",")); err != nil { return &sysError{"mount " + fstype, target, err} }
+	return nil
+}
+
+// bindMount exposes source at target, kept private to this namespace.
+func bindMount(source, target string) error {
+	if err := unix.Mount(source, target, "", unix.MS_BIND, ""); err != nil { return &sysError{"bind mount", target, err} }
+	if err := unix.Mount("", target, "", unix.MS_PRIVATE, ""); err != nil { return &sysError{"make private", target, err} }
+	return nil
+}
+
+// unmount lazily detaches target, like umount -l. Not being mounted is not an error.
+func unmount(target string) error {
+	if err := unix.Unmount(target, unix.MNT_DETACH); err != nil && err != unix.EINVAL && err != unix.ENOENT { return &sysError{"umount", target, err} }
+	return nil
+}
+
+// allocateFile creates an image of size bytes with its blocks reserved, so
+// the vault cannot run into a full disk later. Replaces dd if=/dev/zero.
+func allocateFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil { return &sysError{"create", path, err} }
+	defer f.Close()
+	if err := unix.Fallocate(int(f.Fd()), 0, 0, size); err != nil {
+		// Filesystems without fallocate (some overlays, 9p) get a sparse file
+		if err := f.Truncate(size); err != nil { os.Remove(path); return &sysError{"allocate", path, err} }
+	}
+	return nil
+}
+
+// devNumber reads the "major:minor" the kernel publishes for a device in sysfs.
+func devNumber(sysPath string) (uint32, uint32, error) {
+	data, err := os.ReadFile(sysPath)
+	if err != nil { return 0, 0, err }
+	maj, min, _ := strings.Cut(strings.TrimSpace(string(data)), ":")
+	major, err := strconv.ParseUint(maj, 10, 32)
+	if err != nil { return 0, 0, err }
+	minor, err := strconv.ParseUint(min, 10, 32)
+	if err != nil { return 0, 0, err }
+	return uint32(major), uint32(minor), nil
+}
+
+// makeNode creates a device node, the job udev does on a normal host. The
+// numbers come from sysfs when it knows the device, the fallbacks otherwise.
+func makeNode(path string, mode uint32, sysPath string, major, minor uint32) error {
+	if fileExist(path) { return nil }
+	if maj, min, err := devNumber(sysPath); err == nil { major, minor = maj, min }
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := unix.Mknod(path, mode|0660, int(unix.Mkdev(major, minor))); err != nil && err != unix.EEXIST { return &sysError{"mknod", path, err} }
+	return nil
+}
+
+// ensureNodes creates the control nodes a privileged container may lack.
+// Loop device nodes are created on demand by attachLoop.
+func ensureNodes() error {
+	if err := makeNode("/dev/loop-control", unix.S_IFCHR, "/sys/class/misc/loop-control/dev", 10, 237); err != nil { return err }
+	return makeNode("/dev/mapper/control", unix.S_IFCHR, "/sys/class/misc/device-mapper/dev", 10, 236)
+}
+
+// attachLoop binds file to a free loop device and returns the device path.
+func attachLoop(file string) (string, error) {
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil { return "", &sysError{"open", "/dev/loop-control", err} }
+	defer ctl.Close()
+	backing, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil { return "", &sysError{"open", file, err} }
+	defer backing.Close()
+
+	for attempt := 0; attempt < 5; attempt++ {
+		n, err := unix.IoctlRetInt(int(ctl.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil { return "", &sysError{"LOOP_CTL_GET_FREE", "/dev/loop-control", err} }
+		dev := fmt.Sprintf("/dev/loop%d", n)
+		if err := makeNode(dev, unix.S_IFBLK, fmt.Sprintf("/sys/block/loop%d/dev", n), 7, uint32(n)); err != nil { return "", err }
+		loop, err := os.OpenFile(dev, os.O_RDWR, 0)
+		if err != nil { return "", &sysError{"open", dev, err} }
+		err = configureLoop(loop, backing, file)
+		loop.Close()
+		if err == unix.EBUSY { continue } // another session grabbed the same device first
+		if err != nil { return "", &sysError{"LOOP_CONFIGURE", dev, err} }
+		return dev, nil
+	}
+	return "", &sysError{"attach loop", file, unix.EBUSY}
+}
+
+func configureLoop(loop, backing *os.File, name string) error {
+	var info unix.LoopInfo64
+	copy(info.File_name[:], name)
+	err := unix.IoctlLoopConfigure(int(loop.Fd()), &unix.LoopConfig{Fd: uint32(backing.Fd()), Info: info})
+	if err != unix.EINVAL && err != unix.ENOTTY { return err }
+	// LOOP_CONFIGURE needs Linux 5.8, older kernels take two steps
+	if err := unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_SET_FD, int(backing.Fd())); err != nil { return err }
+	if err := unix.IoctlLoopSetStatus64(int(loop.Fd()), &info); err != nil {
+		unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+		return err
+	}
+	return nil
+}
+
+// detachLoop releases a loop device. An already free device is not an error.
+func detachLoop(dev string) error {
+	loop, err := os.OpenFile(dev, os.O_RDONLY, 0)
+	if err != nil { return &sysError{"open", dev, err} }
+	defer loop.Close()
+	if err := unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0); err != nil && err != unix.ENXIO { return &sysError{"LOOP_CLR_FD", dev, err} }
+	return nil
+}
+
+// loopBacking returns the file a loop device is bound to, or "" when it is free.
+func loopBacking(dev string) string {
+	data, _ := os.ReadFile("/sys/block/" + filepath.Base(dev) + "/loop/backing_file")
+	return strings.TrimSpace(string(data))
+}
+
+// dmDevice finds the dm-N block device behind a device-mapper name.
+func dmDevice(name string) (string, bool) {
+	paths, _ := filepath.Glob("/sys/block/dm-*/dm/name")
+	for _, p := range paths {
+		if data, err := os.ReadFile(p); err == nil && strings.TrimSpace(string(data)) == name { return filepath.Base(filepath.Dir(filepath.Dir(p))), true }
+	}
+	return "", false
+}
+
+func dmExists(name string) bool { _, ok := dmDevice(name); return ok }
+
+// dmNode creates /dev/mapper/<name> for an active mapping. Replaces 'dmsetup mknodes'.
+func dmNode(name string) error {
+	dev, ok := dmDevice(name)
+	if !ok { return &sysError{"find mapper", name, unix.ENODEV} }
+	return makeNode("/dev/mapper/"+name, unix.S_IFBLK, "/sys/block/"+dev+"/dev", 0, 0)
+}
+
+// dmIoctl is struct dm_ioctl from <linux/dm-ioctl.h>, 312 bytes.
+type dmIoctl struct {
+	Version     [3]uint32
+	DataSize    uint32
+	DataStart   uint32
+	TargetCount uint32
+	OpenCount   int32
+	Flags       uint32
+	EventNr     uint32
+	_           uint32
+	Dev         uint64
+	Name        [128]byte
+	UUID        [129]byte
+	_           [7]byte
+}
+
+const (
+	dmDevRemove      = 0xc138fd04 // _IOWR(0xfd, DM_DEV_REMOVE_CMD, struct dm_ioctl)
+	dmDeferredRemove = 1 << 17
+)
+
+// dmRemove removes a mapping, deferred until its last user closes it if it is
+// still busy. This is the fallback when 'cryptsetup close' fails.
+func dmRemove(name string) error {
+	ctl, err := os.OpenFile("/dev/mapper/control", os.O_RDWR, 0)
+	if err != nil { return &sysError{"open", "/dev/mapper/control", err} }
+	defer ctl.Close()
+	req := dmIoctl{Version: [3]uint32{4, 0, 0}, DataSize: uint32(unsafe.Sizeof(dmIoctl{})), DataStart: uint32(unsafe.Sizeof(dmIoctl{})), Flags: dmDeferredRemove}
+	copy(req.Name[:len(req.Name)-1], name)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, ctl.Fd(), dmDevRemove, uintptr(unsafe.Pointer(&req))); errno != 0 && errno != unix.ENXIO { return &sysError{"DM_DEV_REMOVE", name, errno} }
+	os.Remove("/dev/mapper/" + name)
+	return nil
+}
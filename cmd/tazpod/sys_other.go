@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+// Ghost mode only exists inside the Linux container. These stubs keep the
+// CLI building elsewhere for the commands that drive Docker.
+
+func privateNamespace() error                          { return errUnsupported }
+func mountFS(string, string, string, string) error     { return errUnsupported }
+func bindMount(string, string) error                   { return errUnsupported }
+func unmount(string) error                             { return errUnsupported }
+func allocateFile(string, int64) error                 { return errUnsupported }
+func ensureNodes() error                               { return errUnsupported }
+func attachLoop(string) (string, error)                { return "", errUnsupported }
+func detachLoop(string) error                          { return errUnsupported }
+func loopBacking(string) string                        { return "" }
+func dmExists(string) bool                             { return false }
+func dmNode(string) error                              { return errUnsupported }
+func dmRemove(string) error                            { return errUnsupported }
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ttyIdle returns how long ago the terminal last received input. The kernel
+// updates the atime of a TTY on reads, which is what w(1) reports as IDLE.
+func ttyIdle(f *os.File) (time.Duration, bool) {
+	if !term.IsTerminal(int(f.Fd())) { return 0, false }
+	var st syscall.Stat_t
+	if syscall.Fstat(int(f.Fd()), &st) != nil { return 0, false }
+	return time.Since(time.Unix(st.Atim.Unix())), true
+}
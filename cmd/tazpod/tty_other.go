@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// ttyIdle is only meaningful inside the Linux container.
+func ttyIdle(*os.File) (time.Duration, bool) { return 0, false }
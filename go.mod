@@ -3,8 +3,8 @@ module tazpod
 go 1.23.2
 
 require (
+	golang.org/x/crypto v0.32.0
+	golang.org/x/sys v0.29.0
 	golang.org/x/term v0.28.0
 	gopkg.in/yaml.v3 v3.0.1
 )
-
-require golang.org/x/sys v0.29.0 // indirect
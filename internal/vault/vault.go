@@ -20,7 +20,6 @@ const (
 	GhostEnvVar   = "TAZPOD_GHOST_MODE"
 	TazPodUID     = 1000
 	TazPodGID     = 1000
-	StayMarker    = "/tmp/.tazpod_stay"
 	SecretsYAML   = "/workspace/secrets.yml"
 )
 
@@ -32,12 +31,7 @@ func Unlock() {
 	fmt.Println("👻 Entering Ghost Mode (Private Namespace)...")
 	cmd := exec.Command("sudo", "unshare", "--mount", "--propagation", "private", "/usr/local/bin/tazpod", "internal-ghost")
 	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-	err := cmd.Run()
-	if utils.FileExist(StayMarker) {
-		os.Remove(StayMarker)
-		os.Exit(2)
-	}
-	if err != nil {
+	if err := cmd.Run(); err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitError.ExitCode())
 		}
@@ -173,15 +167,6 @@ func CleanupMappers() {
 	}
 }
 
-func Lock() {
-	if os.Getenv(GhostEnvVar) == "true" {
-		fmt.Println("🔒 Locking requested...")
-		os.Create(StayMarker)
-		syscall.Kill(os.Getppid(), syscall.SIGKILL)
-		return
-	}
-}
-
 func Reinit() {
 	if os.Getenv(GhostEnvVar) == "true" {
 		fmt.Println("❌ Cannot reinit inside Ghost Mode. Run 'tazpod lock' first.")